@@ -15,7 +15,9 @@
 package netpoll
 
 import (
+	"context"
 	"net"
+	"os"
 	"time"
 )
 
@@ -23,6 +25,62 @@ import (
 // Return: error is unused which will be ignored directly.
 type CloseCallback func(connection Connection) error
 
+// CloseReason indicates why the connection is closed.
+type CloseReason int32
+
+const (
+	// CloseReasonNone means the connection has not been closed.
+	CloseReasonNone CloseReason = iota
+	// CloseReasonUser means the connection is closed by calling Close.
+	CloseReasonUser
+	// CloseReasonShutdown means the connection is closed by EventLoop.Shutdown.
+	CloseReasonShutdown
+	// CloseReasonHangup means the connection is hung up by the peer, such as EOF or ECONNRESET.
+	CloseReasonHangup
+	// CloseReasonReadError means the poller failed to read from the connection.
+	CloseReasonReadError
+	// CloseReasonWriteError means the connection is closed after failing to write.
+	CloseReasonWriteError
+	// CloseReasonWriteTimeout means the connection is closed after a write timeout.
+	CloseReasonWriteTimeout
+	// CloseReasonMemoryLimit means the connection is closed by MemoryPolicyCloseLargest.
+	CloseReasonMemoryLimit
+)
+
+var closeReasons = [...]string{
+	CloseReasonNone:         "none",
+	CloseReasonUser:         "closed by user",
+	CloseReasonShutdown:     "shutdown",
+	CloseReasonHangup:       "hangup",
+	CloseReasonReadError:    "read error",
+	CloseReasonWriteError:   "write error",
+	CloseReasonWriteTimeout: "write timeout",
+	CloseReasonMemoryLimit:  "memory limit",
+}
+
+// String implements fmt.Stringer.
+func (r CloseReason) String() string {
+	if r >= 0 && int(r) < len(closeReasons) {
+		return closeReasons[r]
+	}
+	return "unknown"
+}
+
+// ConnectionStats is the statistics of a connection during its lifetime.
+type ConnectionStats struct {
+	CreatedAt    time.Time // the time when the connection is initialized
+	LastActive   time.Time // the time of the latest data read or written
+	BytesRead    int64     // total bytes read from the socket
+	BytesWritten int64     // total bytes written to the socket
+	ReadCalls    int64     // the number of read syscalls
+	WriteCalls   int64     // the number of write syscalls
+	WriteStalls  int64     // the number of waiting for writable when the socket write buffer is full
+	InputLen     int       // the length of the readable data in the input buffer
+	OutputLen    int       // the length of the data in the output buffer which has not been sent
+	InputCap     int       // the total size of the buffers held by the input buffer, including the data not released
+	OutputCap    int       // the total size of the buffers held by the output buffer
+}
+
 // Connection supports reading and writing simultaneously,
 // but does not support simultaneous reading or writing by multiple goroutines.
 // It maintains its own input/output buffer, and provides nocopy API for reading and writing.
@@ -67,6 +125,44 @@ type Connection interface {
 	// the local resources, which bound to the idle connection, when hangup by the peer. No need another goroutine
 	// to polling check connection status.
 	AddCloseCallback(callback CloseCallback) error
+
+	// Set binds the value to the connection with the key, which is concurrency-safe.
+	// The values are kept across OnConnect and OnRequest, and will be cleared after all CloseCallbacks are called.
+	// Setting a nil value deletes the key.
+	Set(key, value interface{})
+
+	// Get returns the value bound to the connection with the key, and ok is false if there is no such key.
+	Get(key interface{}) (value interface{}, ok bool)
+
+	// Stats returns the statistics of the connection.
+	Stats() (stats ConnectionStats)
+
+	// CloseReason returns the reason why the connection is closed, and the underlying error if there is any.
+	// It returns CloseReasonNone if the connection is still active, and can be called in CloseCallback.
+	CloseReason() (reason CloseReason, err error)
+
+	// CloseRead shuts down the reading side of the connection.
+	// The data already in the input buffer can still be read, after that Reader will return ErrEOF.
+	CloseRead() error
+
+	// CloseWrite flushes the output buffer and then shuts down the writing side of the connection,
+	// so the peer will receive EOF while the connection is still readable.
+	// Writer can no longer be flushed after CloseWrite.
+	CloseWrite() error
+
+	// WriteFile sends n bytes of f from the offset off by sendfile, without copying them to userspace.
+	// The buffered data of Writer is flushed before, and WriteFile blocks until all the bytes are sent
+	// or the write timeout, like Flush. It returns ErrEOF if f has fewer than off+n bytes.
+	WriteFile(f *os.File, off, n int64) (written int64, err error)
+
+	// SpliceTo moves the next n bytes of the connection to dst, and the bytes that have not been
+	// read into the input buffer are moved by splice without entering userspace on Linux.
+	// It blocks until all the bytes are moved, and returns ErrEOF if the connection is closed by the peer before that.
+	// The data read before is released like Reader.Release.
+	// If dst is not created by netpoll or splice is unsupported, the data is copied instead.
+	// WriteFile and SpliceTo lock the flushing for each chunk, so the data flushed concurrently may be
+	// sent between the chunks.
+	SpliceTo(dst Connection, n int64) (written int64, err error)
 }
 
 // Conn extends net.Conn, but supports getting the conn's fd.
@@ -91,5 +187,8 @@ type Listener interface {
 type Dialer interface {
 	DialConnection(network, address string, timeout time.Duration) (connection Connection, err error)
 
+	// DialContext dials until the ctx is done, which may be canceled by the caller.
+	DialContext(ctx context.Context, network, address string) (connection Connection, err error)
+
 	DialTimeout(network, address string, timeout time.Duration) (conn net.Conn, err error)
 }
@@ -0,0 +1,89 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"time"
+)
+
+// BufferPolicy is the sizing policy of the input buffer of connections.
+// The zero value of each field means the default value.
+type BufferPolicy struct {
+	// InitBookSize is the size read from the socket at once when the connection is created.
+	// If zero, defaults to 512B.
+	InitBookSize int
+
+	// MaxBookSize is the maximum size read at once, which is at most 8MB.
+	// If zero, defaults to 8MB.
+	MaxBookSize int
+
+	// GrowthFactor multiplies the size read at once when the last read fills it, until MaxBookSize.
+	// If zero, defaults to 2, and 1 means never growing.
+	GrowthFactor int
+
+	// InitBufferSize is the capacity of the buffer node read into when the connection is created or shrunk,
+	// which grows with the size of the data between two Release.
+	// It is not limited by LinkBufferCap, and it is rounded up to a power of 2 by the allocator,
+	// so the node of a connection holding little data can be as small as InitBufferSize.
+	// If zero, defaults to 8KB.
+	InitBufferSize int
+
+	// ShrinkAfterIdle resets the sizes to InitBookSize and InitBufferSize when the connection
+	// has not read or written for the duration. If zero, the sizes are never shrunk.
+	// Besides, the input buffer of the idle connection with OnRequest is released
+	// if all the data has been read and released, and it is allocated again when data arrives.
+	ShrinkAfterIdle time.Duration
+}
+
+const (
+	defaultBookSize     = block1k / 2
+	defaultGrowthFactor = 2
+)
+
+var defaultBufferPolicy = BufferPolicy{}.normalize()
+
+// normalize fills the default values and limits the sizes.
+func (p BufferPolicy) normalize() BufferPolicy {
+	if p.MaxBookSize <= 0 || p.MaxBookSize > mallocMax {
+		p.MaxBookSize = mallocMax
+	}
+	if p.InitBookSize <= 0 {
+		p.InitBookSize = defaultBookSize
+	}
+	if p.InitBookSize > p.MaxBookSize {
+		p.InitBookSize = p.MaxBookSize
+	}
+	if p.GrowthFactor <= 0 {
+		p.GrowthFactor = defaultGrowthFactor
+	}
+	if p.InitBufferSize <= 0 {
+		p.InitBufferSize = pagesize
+	}
+	if p.InitBufferSize > mallocMax {
+		p.InitBufferSize = mallocMax
+	}
+	return p
+}
+
+// grow returns the size read at once next time after the last read fills bookSize.
+func (p *BufferPolicy) grow(bookSize int) int {
+	if bookSize >= p.MaxBookSize || p.GrowthFactor == 1 {
+		return bookSize
+	}
+	if bookSize > p.MaxBookSize/p.GrowthFactor {
+		return p.MaxBookSize
+	}
+	return bookSize * p.GrowthFactor
+}
@@ -28,13 +28,17 @@ const (
 	// Dial timeout
 	ErrDialTimeout = syscall.Errno(0x103)
 	// Calling dialer without timeout.
-	ErrDialNoDeadline = syscall.Errno(0x104) // TODO: no-deadline support in future
+	ErrDialNoDeadline = syscall.Errno(0x104) // unused, dialing without deadline is supported by DialContext
 	// The calling function not support.
 	ErrUnsupported = syscall.Errno(0x105)
 	// Same as io.EOF
 	ErrEOF = syscall.Errno(0x106)
 	// Write I/O buffer timeout, calling by Connection.Writer
 	ErrWriteTimeout = syscall.Errno(0x107)
+	// The line is longer than the limit, calling by Reader.UntilBytesLimit
+	ErrLineTooLong = syscall.Errno(0x108)
+	// There is not enough data and reading would block, calling by Reader.TryNext and Reader.TryPeek
+	ErrWouldBlock = syscall.Errno(0x109)
 )
 
 const ErrnoMask = 0xFF
@@ -97,4 +101,6 @@ var errnos = [...]string{
 	ErrnoMask & ErrUnsupported:    "netpoll dose not support",
 	ErrnoMask & ErrEOF:            "EOF",
 	ErrnoMask & ErrWriteTimeout:   "connection write timeout",
+	ErrnoMask & ErrLineTooLong:    "line too long",
+	ErrnoMask & ErrWouldBlock:     "would block",
 }
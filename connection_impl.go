@@ -18,6 +18,7 @@
 package netpoll
 
 import (
+	"log"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -46,14 +47,47 @@ type connection struct {
 	inputBarrier    *barrier
 	outputBarrier   *barrier
 	supportZeroCopy bool
-	maxSize         int // The maximum size of data between two Release().
-	bookSize        int // The size of data that can be read at once.
+	maxSize         int          // The maximum size of data between two Release().
+	bookSize        int          // The size of data that can be read at once.
+	bufferPolicy    BufferPolicy // The sizing policy of bookSize and maxSize.
+	shrinkTimer     *time.Timer  // The timer of trimming inputBuffer after BufferPolicy.ShrinkAfterIdle.
+	shrinkArmed     int32        // 1 if shrinkTimer is pending, and 2 after shrinkTimer is stopped by closing.
+	flushCause      atomic.Value // *closeCause, the latest flush failure which may cause closing.
+	closeCause      atomic.Value // *closeCause, set once the connection is closed.
+	attachments     sync.Map     // values bound by Set, which are cleared when closing.
+	stats           connStats
+	unixMsg         *unixMsg   // the ancillary data received by unix connections.
+	outer           Connection // the TCPConnection or UnixConnection embedding it, which is passed to the callbacks.
 }
 
 var _ Connection = &connection{}
 var _ Reader = &connection{}
 var _ Writer = &connection{}
 
+// newConnection creates the connection accepted from network, which is embedded in TCPConnection or UnixConnection
+// for TCP or unix networks, so that it can be asserted as TCPConn or UnixConn in the callbacks.
+func newConnection(network string) *connection {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		var tc = &TCPConnection{}
+		tc.outer = tc
+		return &tc.connection
+	case "unix", "unixgram", "unixpacket":
+		var uc = &UnixConnection{}
+		uc.outer = uc
+		return &uc.connection
+	}
+	return &connection{}
+}
+
+// self returns the Connection passed to the callbacks, which is the outer connection if it is embedded.
+func (c *connection) self() Connection {
+	if c.outer != nil {
+		return c.outer
+	}
+	return c
+}
+
 // Reader implements Connection.
 func (c *connection) Reader() Reader {
 	return c
@@ -69,6 +103,28 @@ func (c *connection) IsActive() bool {
 	return c.isCloseBy(none)
 }
 
+// CloseReason implements Connection.
+func (c *connection) CloseReason() (reason CloseReason, err error) {
+	if cause, ok := c.closeCause.Load().(*closeCause); ok {
+		return cause.reason, cause.err
+	}
+	return CloseReasonNone, nil
+}
+
+// Set implements Connection.
+func (c *connection) Set(key, value interface{}) {
+	if value == nil {
+		c.attachments.Delete(key)
+		return
+	}
+	c.attachments.Store(key, value)
+}
+
+// Get implements Connection.
+func (c *connection) Get(key interface{}) (value interface{}, ok bool) {
+	return c.attachments.Load(key)
+}
+
 // SetIdleTimeout implements Connection.
 func (c *connection) SetIdleTimeout(timeout time.Duration) error {
 	if timeout > 0 {
@@ -111,6 +167,46 @@ func (c *connection) Peek(n int) (buf []byte, err error) {
 	return c.inputBuffer.Peek(n)
 }
 
+// NextVec implements Connection.
+func (c *connection) NextVec(n int) (p [][]byte, err error) {
+	if err = c.waitRead(n); err != nil {
+		return p, err
+	}
+	return c.inputBuffer.NextVec(n)
+}
+
+// PeekVec implements Connection.
+func (c *connection) PeekVec(n int) (p [][]byte, err error) {
+	if err = c.waitRead(n); err != nil {
+		return p, err
+	}
+	return c.inputBuffer.PeekVec(n)
+}
+
+// TryNext implements Connection.
+func (c *connection) TryNext(n int) (p []byte, err error) {
+	if err = c.tryWaitRead(n); err != nil {
+		return p, err
+	}
+	return c.inputBuffer.Next(n)
+}
+
+// TryPeek implements Connection.
+func (c *connection) TryPeek(n int) (buf []byte, err error) {
+	if err = c.tryWaitRead(n); err != nil {
+		return buf, err
+	}
+	return c.inputBuffer.Peek(n)
+}
+
+// PeekAtLeast implements Connection.
+func (c *connection) PeekAtLeast(min int) (buf []byte, err error) {
+	if err = c.waitRead(min); err != nil {
+		return buf, err
+	}
+	return c.inputBuffer.PeekAtLeast(min)
+}
+
 // Skip implements Connection.
 func (c *connection) Skip(n int) (err error) {
 	if err = c.waitRead(n); err != nil {
@@ -175,6 +271,21 @@ func (c *connection) Until(delim byte) (line []byte, err error) {
 	}
 }
 
+// UntilBytes implements Connection.
+func (c *connection) UntilBytes(delim []byte) (line []byte, err error) {
+	return untilBytes(c.inputBuffer, c.waitRead, delim, 0)
+}
+
+// UntilBytesLimit implements Connection.
+func (c *connection) UntilBytesLimit(delim []byte, limit int) (line []byte, err error) {
+	return untilBytes(c.inputBuffer, c.waitRead, delim, limit)
+}
+
+// IndexBytes implements Connection.
+func (c *connection) IndexBytes(sep []byte) (index int) {
+	return c.inputBuffer.IndexBytes(sep)
+}
+
 // ReadString implements Connection.
 func (c *connection) ReadString(n int) (s string, err error) {
 	if err = c.waitRead(n); err != nil {
@@ -199,6 +310,82 @@ func (c *connection) ReadByte() (b byte, err error) {
 	return c.inputBuffer.ReadByte()
 }
 
+// ReadUint16 implements Connection.
+func (c *connection) ReadUint16() (v uint16, err error) {
+	if err = c.waitRead(2); err != nil {
+		return v, err
+	}
+	return c.inputBuffer.ReadUint16()
+}
+
+// ReadUint32 implements Connection.
+func (c *connection) ReadUint32() (v uint32, err error) {
+	if err = c.waitRead(4); err != nil {
+		return v, err
+	}
+	return c.inputBuffer.ReadUint32()
+}
+
+// ReadUint64 implements Connection.
+func (c *connection) ReadUint64() (v uint64, err error) {
+	if err = c.waitRead(8); err != nil {
+		return v, err
+	}
+	return c.inputBuffer.ReadUint64()
+}
+
+// ReadUint16LE implements Connection.
+func (c *connection) ReadUint16LE() (v uint16, err error) {
+	if err = c.waitRead(2); err != nil {
+		return v, err
+	}
+	return c.inputBuffer.ReadUint16LE()
+}
+
+// ReadUint32LE implements Connection.
+func (c *connection) ReadUint32LE() (v uint32, err error) {
+	if err = c.waitRead(4); err != nil {
+		return v, err
+	}
+	return c.inputBuffer.ReadUint32LE()
+}
+
+// ReadUint64LE implements Connection.
+func (c *connection) ReadUint64LE() (v uint64, err error) {
+	if err = c.waitRead(8); err != nil {
+		return v, err
+	}
+	return c.inputBuffer.ReadUint64LE()
+}
+
+// ReadUvarint implements Connection.
+func (c *connection) ReadUvarint() (v uint64, err error) {
+	if err = c.waitUvarint(); err != nil {
+		return v, err
+	}
+	return c.inputBuffer.ReadUvarint()
+}
+
+// ReadVarint implements Connection.
+func (c *connection) ReadVarint() (v int64, err error) {
+	if err = c.waitUvarint(); err != nil {
+		return v, err
+	}
+	return c.inputBuffer.ReadVarint()
+}
+
+// waitUvarint waits until the varint is complete or overflows.
+func (c *connection) waitUvarint() (err error) {
+	for n := 1; ; n = c.inputBuffer.Len() + 1 {
+		if err = c.waitRead(n); err != nil {
+			return err
+		}
+		if c.inputBuffer.uvarintLen() != 0 {
+			return nil
+		}
+	}
+}
+
 // ------------------------------------------ implement zero-copy writer ------------------------------------------
 
 // Malloc implements Connection.
@@ -218,7 +405,7 @@ func (c *connection) MallocLen() (length int) {
 // If empty, it will call syscall.Write to send data directly,
 // otherwise the buffer will be sent asynchronously by the epoll trigger.
 func (c *connection) Flush() error {
-	if !c.IsActive() || !c.lock(flushing) {
+	if !c.IsActive() || !c.isUnlock(outputShutdown) || !c.lock(flushing) {
 		return Exception(ErrConnClosed, "when flush")
 	}
 	defer c.unlock(flushing)
@@ -256,10 +443,53 @@ func (c *connection) WriteByte(b byte) (err error) {
 	return c.outputBuffer.WriteByte(b)
 }
 
+// WriteUint16 implements Connection.
+func (c *connection) WriteUint16(v uint16) (err error) {
+	return c.outputBuffer.WriteUint16(v)
+}
+
+// WriteUint32 implements Connection.
+func (c *connection) WriteUint32(v uint32) (err error) {
+	return c.outputBuffer.WriteUint32(v)
+}
+
+// WriteUint64 implements Connection.
+func (c *connection) WriteUint64(v uint64) (err error) {
+	return c.outputBuffer.WriteUint64(v)
+}
+
+// WriteUint16LE implements Connection.
+func (c *connection) WriteUint16LE(v uint16) (err error) {
+	return c.outputBuffer.WriteUint16LE(v)
+}
+
+// WriteUint32LE implements Connection.
+func (c *connection) WriteUint32LE(v uint32) (err error) {
+	return c.outputBuffer.WriteUint32LE(v)
+}
+
+// WriteUint64LE implements Connection.
+func (c *connection) WriteUint64LE(v uint64) (err error) {
+	return c.outputBuffer.WriteUint64LE(v)
+}
+
+// WriteUvarint implements Connection.
+func (c *connection) WriteUvarint(v uint64) (err error) {
+	return c.outputBuffer.WriteUvarint(v)
+}
+
+// WriteVarint implements Connection.
+func (c *connection) WriteVarint(v int64) (err error) {
+	return c.outputBuffer.WriteVarint(v)
+}
+
 // ------------------------------------------ implement net.Conn ------------------------------------------
 
 // Read behavior is the same as net.Conn, it will return io.EOF if buffer is empty.
 func (c *connection) Read(p []byte) (n int, err error) {
+	if debugMode {
+		debugCheck(p)
+	}
 	l := len(p)
 	if l == 0 {
 		return 0, nil
@@ -280,7 +510,10 @@ func (c *connection) Read(p []byte) (n int, err error) {
 
 // Write will Flush soon.
 func (c *connection) Write(p []byte) (n int, err error) {
-	if !c.IsActive() || !c.lock(flushing) {
+	if debugMode {
+		debugCheck(p)
+	}
+	if !c.IsActive() || !c.isUnlock(outputShutdown) || !c.lock(flushing) {
 		return 0, Exception(ErrConnClosed, "when write")
 	}
 	defer c.unlock(flushing)
@@ -294,7 +527,47 @@ func (c *connection) Write(p []byte) (n int, err error) {
 
 // Close implements Connection.
 func (c *connection) Close() error {
-	return c.onClose()
+	return c.onClose(CloseReasonUser)
+}
+
+// CloseRead implements Connection.
+func (c *connection) CloseRead() error {
+	if !c.IsActive() {
+		return Exception(ErrConnClosed, "when close read")
+	}
+	if !c.lock(inputShutdown) {
+		return nil
+	}
+	// stop monitoring readable first, otherwise the poller will hang up the connection.
+	if c.operator.poll != nil {
+		c.operator.Control(PollShutRead)
+	} else {
+		c.operator.shutRead()
+	}
+	c.triggerRead()
+	if err := syscall.Shutdown(c.fd, syscall.SHUT_RD); err != nil {
+		return Exception(err, "when close read")
+	}
+	return nil
+}
+
+// CloseWrite implements Connection.
+func (c *connection) CloseWrite() error {
+	if !c.IsActive() || !c.lock(flushing) {
+		return Exception(ErrConnClosed, "when close write")
+	}
+	defer c.unlock(flushing)
+	if !c.lock(outputShutdown) {
+		return nil
+	}
+	c.outputBuffer.Flush()
+	if err := c.flush(); err != nil {
+		return err
+	}
+	if err := syscall.Shutdown(c.fd, syscall.SHUT_WR); err != nil {
+		return Exception(err, "when close write")
+	}
+	return nil
 }
 
 // ------------------------------------------ private ------------------------------------------
@@ -313,19 +586,32 @@ func (c *connection) init(conn Conn, opts *options) (err error) {
 	// init buffer, barrier, finalizer
 	c.readTrigger = make(chan struct{}, 1)
 	c.writeTrigger = make(chan error, 1)
-	c.bookSize, c.maxSize = block1k/2, pagesize
-	c.inputBuffer, c.outputBuffer = NewLinkBuffer(pagesize), NewLinkBuffer()
+	c.bufferPolicy = defaultBufferPolicy
+	if opts != nil && opts.bufferPolicy != nil {
+		c.bufferPolicy = *opts.bufferPolicy
+	}
+	c.bookSize, c.maxSize = c.bufferPolicy.InitBookSize, c.bufferPolicy.InitBufferSize
+	c.initShrink()
+	c.inputBuffer, c.outputBuffer = newLinkBuffer(newSizedLinkBufferNode(c.bufferPolicy.InitBufferSize)), NewLinkBuffer()
 	c.inputBarrier, c.outputBarrier = barrierPool.Get().(*barrier), barrierPool.Get().(*barrier)
+	c.stats.init()
 
 	c.initNetFD(conn) // conn must be *netFD{}
 	c.initFDOperator()
 	c.initFinalizer()
+	c.initMemory()
 
 	syscall.SetNonblock(c.fd, true)
-	// enable TCP_NODELAY by default
 	switch c.network {
 	case "tcp", "tcp4", "tcp6":
-		setTCPNoDelay(c.fd, true)
+		// the socket options of dialed connections have been set before connecting.
+		if opts == nil || !opts.sockoptsSet {
+			if err = setTCPSockopts(c.fd, opts); err != nil {
+				log.Printf("netpoll: set sockopts(fd=%d) failed: %s", c.fd, err.Error())
+			}
+		}
+	case "unix", "unixgram", "unixpacket":
+		c.initUnixMsg()
 	}
 	// check zero-copy
 	if setZeroCopy(c.fd) == nil && setBlockZeroCopySend(c.fd, defaultZeroCopyTimeoutSec, 0) == nil {
@@ -370,6 +656,11 @@ func (c *connection) initFinalizer() {
 		freeop(c.operator)
 		c.netFD.Close()
 		c.closeBuffer()
+		c.stopShrink()
+		c.attachments.Range(func(key, value interface{}) bool {
+			c.attachments.Delete(key)
+			return true
+		})
 		return nil
 	})
 }
@@ -395,12 +686,16 @@ func (c *connection) waitRead(n int) (err error) {
 	}
 	atomic.StoreInt64(&c.waitReadSize, int64(n))
 	defer atomic.StoreInt64(&c.waitReadSize, 0)
+	c.memUnhold()
 	if c.readTimeout > 0 {
 		return c.waitReadWithTimeout(n)
 	}
 	// wait full n
 	for c.inputBuffer.Len() < n {
 		if c.IsActive() {
+			if !c.isUnlock(inputShutdown) {
+				return Exception(ErrEOF, "wait read")
+			}
 			<-c.readTrigger
 			continue
 		}
@@ -413,6 +708,18 @@ func (c *connection) waitRead(n int) (err error) {
 	return nil
 }
 
+// tryWaitRead is the non-blocking waitRead, which returns ErrWouldBlock if more data may arrive.
+func (c *connection) tryWaitRead(n int) (err error) {
+	if n <= c.inputBuffer.Len() {
+		return nil
+	}
+	if c.IsActive() && c.isUnlock(inputShutdown) {
+		return Exception(ErrWouldBlock, "try read")
+	}
+	// the connection has been closed or shut down, waitRead returns without blocking.
+	return c.waitRead(n)
+}
+
 // waitReadWithTimeout will wait full n bytes or until timeout.
 func (c *connection) waitReadWithTimeout(n int) (err error) {
 	// set read timeout
@@ -433,6 +740,10 @@ func (c *connection) waitReadWithTimeout(n int) (err error) {
 			}
 			break
 		}
+		if !c.isUnlock(inputShutdown) {
+			err = Exception(ErrEOF, "wait read")
+			break
+		}
 
 		select {
 		case <-c.readTimer.C:
@@ -494,7 +805,10 @@ func (c *connection) flush() error {
 	// TODO: Let the upper layer pass in whether to use ZeroCopy.
 	var bs = c.outputBuffer.GetBytes(c.outputBarrier.bs)
 	var n, err = sendmsg(c.fd, bs, c.outputBarrier.ivs, false && c.supportZeroCopy)
-	if err != nil && err != syscall.EAGAIN {
+	c.stats.onWrite(n)
+	// EINPROGRESS means the TCP Fast Open connection has not been established, and the data will be sent after that.
+	if err != nil && err != syscall.EAGAIN && err != syscall.EINPROGRESS {
+		c.flushCause.Store(&closeCause{reason: CloseReasonWriteError, err: err})
 		return Exception(err, "when flush")
 	}
 	if n > 0 {
@@ -506,8 +820,10 @@ func (c *connection) flush() error {
 	}
 	// return if write all buffer.
 	if c.outputBuffer.IsEmpty() {
+		c.resetFlushCause()
 		return nil
 	}
+	c.stats.onWriteStall()
 	err = c.operator.Control(PollR2RW)
 	if err != nil {
 		return Exception(err, "when flush")
@@ -521,6 +837,9 @@ func (c *connection) waitFlush() (err error) {
 		select {
 		case err = <-c.writeTrigger:
 		}
+		if err == nil {
+			c.resetFlushCause()
+		}
 		return err
 	}
 
@@ -536,17 +855,25 @@ func (c *connection) waitFlush() (err error) {
 		if !c.writeTimer.Stop() { // clean timer
 			<-c.writeTimer.C
 		}
+		if err == nil {
+			c.resetFlushCause()
+		}
 		return err
 	case <-c.writeTimer.C:
 		select {
 		// try fetch writeTrigger if both cases fires
 		case err = <-c.writeTrigger:
+			if err == nil {
+				c.resetFlushCause()
+			}
 			return err
 		default:
 		}
 		// if timeout, remove write event from poller
 		// we cannot flush it again, since we don't if the poller is still process outputBuffer
 		c.operator.Control(PollRW2R)
-		return Exception(ErrWriteTimeout, c.remoteAddr.String())
+		err = Exception(ErrWriteTimeout, c.remoteAddr.String())
+		c.flushCause.Store(&closeCause{reason: CloseReasonWriteTimeout, err: err})
+		return err
 	}
 }
@@ -48,6 +48,9 @@ const (
 	processing
 	flushing
 	finalizing
+	// inputShutdown and outputShutdown are locked once the connection is half-closed, and never unlocked.
+	inputShutdown
+	outputShutdown
 	// total must be at the bottom.
 	total
 )
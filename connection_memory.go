@@ -0,0 +1,178 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// memCheckInterval is the interval of checking the memory again while it exceeds the limit,
+// since the buffers are released asynchronously and the data may be not received yet when allocating.
+const memCheckInterval = 10 * time.Millisecond
+
+// memReleaseTimeout limits the time waiting for the buffers of the closed connections to be released,
+// which may be delayed by the running OnRequest or the finalizers, before closing more connections.
+const memReleaseTimeout = time.Second
+
+var memLimit struct {
+	policy     int32        // MemoryPolicy
+	onExceeded atomic.Value // func(stats MemoryStats)
+	signal     chan struct{}
+	once       sync.Once
+	conns      sync.Map // the connections which may be closed by MemoryPolicyCloseLargest
+	held       sync.Map // the connections held by MemoryPolicyPauseRead
+}
+
+func setMemoryLimit(config MemoryLimit) error {
+	if config.Limit < 0 {
+		return fmt.Errorf("illegal memory limit [%d]", config.Limit)
+	}
+	atomic.StoreInt32(&memLimit.policy, int32(config.Policy))
+	memLimit.onExceeded.Store(config.OnExceeded)
+	memLimit.once.Do(func() {
+		memLimit.signal = make(chan struct{}, 1)
+		memNotify = func(exceeded bool) {
+			if onExceeded, _ := memLimit.onExceeded.Load().(func(MemoryStats)); exceeded && onExceeded != nil {
+				go onExceeded(ReadMemoryStats())
+			}
+			select {
+			case memLimit.signal <- struct{}{}:
+			default:
+			}
+		}
+		go memMonitor()
+	})
+	atomic.StoreInt64(&memStats.limit, config.Limit)
+	// check the memory already in use.
+	memCheck()
+	return nil
+}
+
+func memPolicy() MemoryPolicy {
+	return MemoryPolicy(atomic.LoadInt32(&memLimit.policy))
+}
+
+// memMonitor takes the actions of the policy when the memory in use exceeds the limit or drops below it.
+func memMonitor() {
+	for range memLimit.signal {
+		if !memExceeded() {
+			memUnholdAll()
+			continue
+		}
+		for memExceeded() && memPolicy() == MemoryPolicyCloseLargest {
+			var inuse = atomic.LoadInt64(&memStats.inuse)
+			var closed = memCloseLargest()
+			if closed == 0 {
+				time.Sleep(memCheckInterval)
+				continue
+			}
+			memWaitRelease(inuse - closed)
+		}
+	}
+}
+
+// memWaitRelease waits until the memory in use drops to target or below the limit, or memReleaseTimeout,
+// so the connections are not closed one after another before the buffers of the closed ones are released.
+// It stops waiting if the limit or the policy is changed.
+func memWaitRelease(target int64) {
+	var limit = atomic.LoadInt64(&memStats.limit)
+	var deadline = time.Now().Add(memReleaseTimeout)
+	for memExceeded() && atomic.LoadInt64(&memStats.inuse) > target && time.Now().Before(deadline) {
+		if atomic.LoadInt64(&memStats.limit) != limit || memPolicy() != MemoryPolicyCloseLargest {
+			return
+		}
+		time.Sleep(memCheckInterval)
+	}
+}
+
+// initMemory tracks the connection, which may be closed by MemoryPolicyCloseLargest.
+// All the connections are tracked since the policy can be changed at any time.
+func (c *connection) initMemory() {
+	memLimit.conns.Store(c, struct{}{})
+	c.AddCloseCallback(func(connection Connection) error {
+		memLimit.conns.Delete(c)
+		return nil
+	})
+}
+
+// memHold reports whether the connection should stop reading because of MemoryPolicyPauseRead,
+// and stops the poller reading it until the memory in use drops below the limit.
+// The connection waiting for more data is not held, otherwise the buffered data can never be released.
+func (c *connection) memHold() bool {
+	if !memExceeded() || memPolicy() != MemoryPolicyPauseRead {
+		return false
+	}
+	c.operator.Control(pollHoldRead)
+	memLimit.held.Store(c, struct{}{})
+	// the memory may drop below the limit or the connection may start waiting before holding,
+	// which is checked after holding, and waitRead checks the hold after setting waitReadSize.
+	if !memExceeded() || atomic.LoadInt64(&c.waitReadSize) > 0 {
+		c.memUnhold()
+		return false
+	}
+	return true
+}
+
+// memUnhold makes the poller read the connection again if it is held.
+func (c *connection) memUnhold() {
+	if atomic.LoadInt32(&c.operator.hold) == 0 {
+		return
+	}
+	memLimit.held.Delete(c)
+	if c.IsActive() && c.operator.poll != nil {
+		c.operator.Control(pollUnholdRead)
+	}
+}
+
+// memUnholdAll makes the poller read the held connections again.
+func memUnholdAll() {
+	memLimit.held.Range(func(key, value interface{}) bool {
+		key.(*connection).memUnhold()
+		return true
+	})
+}
+
+// memCloseLargest closes the connections with the most buffered data until the memory is expected to be under the limit,
+// and returns the size of the buffered data of the closed connections.
+func memCloseLargest() (closed int64) {
+	type sized struct {
+		conn *connection
+		size int
+	}
+	var conns []sized
+	memLimit.conns.Range(func(key, value interface{}) bool {
+		c := key.(*connection)
+		if size := c.inputBuffer.Len() + c.outputBuffer.Len(); size > 0 && c.IsActive() {
+			conns = append(conns, sized{conn: c, size: size})
+		}
+		return true
+	})
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].size > conns[j].size
+	})
+	var excess = atomic.LoadInt64(&memStats.inuse) - atomic.LoadInt64(&memStats.limit)
+	for i := 0; i < len(conns) && excess > closed; i++ {
+		conns[i].conn.onClose(CloseReasonMemoryLimit)
+		closed += int64(conns[i].size)
+	}
+	return closed
+}
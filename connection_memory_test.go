@@ -0,0 +1,166 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"bytes"
+	"math/rand"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMemoryStats(t *testing.T) {
+	var before = ReadMemoryStats()
+	var buf = NewLinkBuffer()
+	_, err := buf.Malloc(block8k)
+	MustNil(t, err)
+	MustNil(t, buf.Flush())
+	var after = ReadMemoryStats()
+	MustTrue(t, after.Allocated >= before.Allocated+block8k)
+	MustTrue(t, after.Nodes > 0)
+	MustNil(t, buf.Close())
+	var closed = ReadMemoryStats()
+	Equal(t, closed.InUse, before.InUse)
+	Equal(t, closed.Nodes, before.Nodes)
+	if !debugMode {
+		// the released nodes are pooled until they are dropped by GC.
+		MustTrue(t, closed.Pooled > after.Pooled)
+		for i := 0; i < 100 && ReadMemoryStats().Pooled > 0; i++ {
+			runtime.GC()
+			time.Sleep(time.Millisecond)
+		}
+		Equal(t, ReadMemoryStats().Pooled, int64(0))
+	}
+}
+
+func TestMemoryLimitPauseRead(t *testing.T) {
+	var data = make([]byte, 4*1024*1024)
+	rand.Read(data)
+
+	r, w := GetSysFdPairs()
+	var rconn, wconn = &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, nil)
+	wconn.init(&netFD{fd: w}, nil)
+	defer rconn.Close()
+	defer wconn.Close()
+
+	var exceeded = make(chan MemoryStats, 1)
+	// the buffers closed uncleanly by the former tests are released by finalizers.
+	runtime.GC()
+	var limit = ReadMemoryStats().InUse + 256*1024
+	err := SetMemoryLimit(MemoryLimit{
+		Limit:  limit,
+		Policy: MemoryPolicyPauseRead,
+		OnExceeded: func(stats MemoryStats) {
+			select {
+			case exceeded <- stats:
+			default:
+			}
+		},
+	})
+	MustNil(t, err)
+	defer SetMemoryLimit(MemoryLimit{})
+
+	go func() {
+		// the slice written is referenced rather than allocated.
+		wconn.WriteBinary(data)
+		wconn.Flush()
+	}()
+	select {
+	case stats := <-exceeded:
+		MustTrue(t, stats.InUse > limit)
+	case <-time.After(time.Second):
+		t.Fatal("the memory limit is not exceeded")
+	}
+	// the held connection is read again after releasing the read data.
+	var received []byte
+	for len(received) < len(data) {
+		buf, err := rconn.Reader().Next(4096)
+		MustNil(t, err)
+		received = append(received, buf...)
+		MustNil(t, rconn.Reader().Release())
+	}
+	MustTrue(t, bytes.Equal(received, data))
+}
+
+func TestMemoryLimitCloseLargest(t *testing.T) {
+	r, w := GetSysFdPairs()
+	defer syscall.Close(w)
+	var rconn = &connection{}
+	// the connections created before setting the limit are closed too.
+	rconn.init(&netFD{fd: r}, nil)
+
+	runtime.GC()
+	var limit = ReadMemoryStats().InUse + 64*1024
+	err := SetMemoryLimit(MemoryLimit{Limit: limit, Policy: MemoryPolicyCloseLargest})
+	MustNil(t, err)
+	defer SetMemoryLimit(MemoryLimit{})
+
+	go func() {
+		syscall.Write(w, make([]byte, 1024*1024))
+	}()
+	var deadline = time.Now().Add(time.Second)
+	for rconn.IsActive() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	reason, _ := rconn.CloseReason()
+	Equal(t, reason, CloseReasonMemoryLimit)
+}
+
+func TestMemoryLimitCloseLargestWaitRelease(t *testing.T) {
+	// the buffers closed uncleanly by the former tests are released by finalizers.
+	runtime.GC()
+	var conns = make([]*connection, 2)
+	for i, size := range []int{512 * 1024, 256 * 1024} {
+		r, w := GetSysFdPairs()
+		defer syscall.Close(w)
+		conns[i] = &connection{}
+		conns[i].init(&netFD{fd: r}, nil)
+		defer conns[i].Close()
+		var data = make([]byte, size)
+		for len(data) > 0 {
+			n, err := syscall.Write(w, data)
+			if err == syscall.EAGAIN {
+				time.Sleep(time.Millisecond)
+				continue
+			}
+			MustNil(t, err)
+			data = data[n:]
+		}
+		for conns[i].Reader().Len() < size {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// the buffers of the largest connection without OnRequest are not released until GC,
+	// and the other one is not closed meanwhile.
+	var limit = ReadMemoryStats().InUse - 128*1024
+	err := SetMemoryLimit(MemoryLimit{Limit: limit, Policy: MemoryPolicyCloseLargest})
+	MustNil(t, err)
+	defer SetMemoryLimit(MemoryLimit{})
+	var deadline = time.Now().Add(2 * time.Second)
+	for conns[0].IsActive() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	reason, _ := conns[0].CloseReason()
+	Equal(t, reason, CloseReasonMemoryLimit)
+	time.Sleep(100 * time.Millisecond)
+	MustTrue(t, conns[1].IsActive())
+}
@@ -38,7 +38,7 @@ func disableGopool() error {
 
 type gracefulExit interface {
 	isIdle() (yes bool)
-	Close() (err error)
+	shutdown() (err error)
 }
 
 // onEvent is the collection of event processing.
@@ -100,10 +100,13 @@ func (c *connection) onPrepare(opts *options) (err error) {
 		c.SetReadTimeout(opts.readTimeout)
 		c.SetWriteTimeout(opts.writeTimeout)
 		c.SetIdleTimeout(opts.idleTimeout)
+		if opts.halfClose {
+			c.operator.OnRdHup = c.onRdHup
+		}
 
 		// calling prepare first and then register.
 		if opts.onPrepare != nil {
-			c.ctx = opts.onPrepare(c)
+			c.ctx = opts.onPrepare(c.self())
 		}
 	}
 
@@ -117,6 +120,28 @@ func (c *connection) onPrepare(opts *options) (err error) {
 	return nil
 }
 
+// prepareRegistered applies the connection options to the connection which has been registered without them,
+// e.g. the connection dialed through a proxy, and then triggers OnConnect or OnRequest.
+func (c *connection) prepareRegistered(opts *options) {
+	c.SetReadTimeout(opts.readTimeout)
+	c.SetWriteTimeout(opts.writeTimeout)
+	c.SetIdleTimeout(opts.idleTimeout)
+	if opts.onPrepare != nil {
+		if ctx := opts.onPrepare(c.self()); ctx != nil {
+			c.ctx = ctx
+		}
+	}
+	c.SetOnConnect(opts.onConnect)
+	if opts.onRequest != nil {
+		c.onRequestCallback.Store(opts.onRequest)
+	}
+	if opts.onConnect != nil {
+		c.onConnect()
+	} else if opts.onRequest != nil && !c.inputBuffer.IsEmpty() {
+		c.onRequest()
+	}
+}
+
 // onConnect is responsible for executing onRequest if there is new data coming after onConnect callback finished.
 func (c *connection) onConnect() {
 	var onConnect, _ = c.onConnectCallback.Load().(OnConnect)
@@ -137,11 +162,11 @@ func (c *connection) onConnect() {
 		},
 		func(c *connection) {
 			if atomic.CompareAndSwapInt32(&connected, 0, 1) {
-				c.ctx = onConnect(c.ctx, c)
+				c.ctx = onConnect(c.ctx, c.self())
 				return
 			}
 			if onRequest != nil {
-				_ = onRequest(c.ctx, c)
+				_ = onRequest(c.ctx, c.self())
 			}
 		},
 	)
@@ -159,7 +184,7 @@ func (c *connection) onRequest() (needTrigger bool) {
 			return c.Reader().Len() > 0
 		},
 		func(c *connection) {
-			_ = onRequest(c.ctx, c)
+			_ = onRequest(c.ctx, c.self())
 		},
 	)
 	// if not processed, should trigger read
@@ -178,6 +203,9 @@ func (c *connection) onProcess(isProcessable func(c *connection) bool, process f
 	}
 	// add new task
 	var task = func() {
+		if debugMode {
+			defer debugRecoverFault(debugPanicOnFault())
+		}
 	START:
 		// `process` must be executed at least once if `isProcessable` in order to cover the `send & close by peer` case.
 		// Then the loop processing must ensure that the connection `IsActive`.
@@ -221,7 +249,7 @@ func (c *connection) closeCallback(needLock bool) (err error) {
 		return nil
 	}
 	for callback := latest.(*callbackNode); callback != nil; callback = callback.pre {
-		callback.fn(c)
+		callback.fn(c.self())
 	}
 	return nil
 }
@@ -242,6 +270,11 @@ func (c *connection) register() (err error) {
 	return nil
 }
 
+// shutdown implements gracefulExit.
+func (c *connection) shutdown() (err error) {
+	return c.onClose(CloseReasonShutdown)
+}
+
 // isIdle implements gracefulExit.
 func (c *connection) isIdle() (yes bool) {
 	return c.isUnlock(processing) &&
@@ -0,0 +1,114 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// trusted returns whether the PROXY protocol header is accepted from addr.
+func (config *ProxyProtocolConfig) trusted(addr net.Addr) bool {
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *TCPAddr:
+		ip = addr.IP
+	default:
+		return false
+	}
+	for _, cidr := range config.TrustedCIDRs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// onProxyConnect reads the PROXY protocol header before applying the connection options,
+// which makes OnConnect and OnRequest see the real client address.
+// The connection is closed if the header is invalid or not received in time.
+func (c *connection) onProxyConnect(config *ProxyProtocolConfig, opts *options) {
+	addr, err := c.readProxyHeader(config)
+	if err != nil {
+		c.Close()
+		return
+	}
+	if addr != nil {
+		addr.Proxy = c.remoteAddr
+		c.remoteAddr = addr
+	}
+	c.prepareRegistered(opts)
+}
+
+// readProxyHeader reads the PROXY protocol v1 or v2 header from the input buffer.
+func (c *connection) readProxyHeader(config *ProxyProtocolConfig) (addr *ProxyAddr, err error) {
+	var deadline = time.Now().Add(config.headerTimeout())
+	defer c.SetReadTimeout(0)
+	var wait = func(n int) error {
+		var timeout = time.Until(deadline)
+		if timeout <= 0 {
+			return Exception(ErrReadTimeout, "proxy protocol header")
+		}
+		c.SetReadTimeout(timeout)
+		return c.waitRead(n)
+	}
+
+	if err = wait(len(proxyV1Prefix)); err != nil {
+		return nil, err
+	}
+	prefix, _ := c.inputBuffer.Peek(len(proxyV1Prefix))
+	switch {
+	case bytes.Equal(prefix, proxyV1Prefix):
+		// the line is at most 107 bytes, including CRLF.
+		var n, i = len(proxyV1Prefix), -1
+		for i < 0 {
+			if n >= proxyV1MaxLength {
+				return nil, errProxyHeader
+			}
+			if err = wait(n + 1); err != nil {
+				return nil, err
+			}
+			i = c.inputBuffer.indexByte('\n', n)
+			n = c.inputBuffer.Len()
+		}
+		if i >= proxyV1MaxLength {
+			return nil, errProxyHeader
+		}
+		line, _ := c.inputBuffer.Next(i + 1)
+		addr, err = parseProxyV1(line)
+	case bytes.Equal(prefix, proxyV2Signature[:len(prefix)]):
+		if err = wait(proxyV2HeaderSize); err != nil {
+			return nil, err
+		}
+		fixed, _ := c.inputBuffer.Peek(proxyV2HeaderSize)
+		var size = proxyV2HeaderSize + int(binary.BigEndian.Uint16(fixed[14:]))
+		if err = wait(size); err != nil {
+			return nil, err
+		}
+		header, _ := c.inputBuffer.Next(size)
+		addr, err = parseProxyV2(header)
+	default:
+		return nil, errProxyHeader
+	}
+	c.inputBuffer.Release()
+	return addr, err
+}
@@ -18,7 +18,11 @@
 package netpoll
 
 import (
+	"os"
+	"runtime"
 	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 // ------------------------------------------ implement FDOperator ------------------------------------------
@@ -26,6 +30,7 @@ import (
 // onHup means close by poller.
 func (c *connection) onHup(p Poll) error {
 	if c.closeBy(poller) {
+		c.closeCause.Store(c.hupCause())
 		c.triggerRead()
 		c.triggerWrite(ErrConnClosed)
 		// It depends on closing by user if OnConnect and OnRequest is nil, otherwise it needs to be released actively.
@@ -41,8 +46,14 @@ func (c *connection) onHup(p Poll) error {
 }
 
 // onClose means close by user.
-func (c *connection) onClose() error {
+func (c *connection) onClose(reason CloseReason) error {
 	if c.closeBy(user) {
+		var cause = &closeCause{reason: reason}
+		// the user closes the connection because of the flush failure in most cases.
+		if fc, _ := c.flushCause.Load().(*closeCause); fc != nil && reason == CloseReasonUser {
+			cause = fc
+		}
+		c.closeCause.Store(cause)
 		c.triggerRead()
 		c.triggerWrite(ErrConnClosed)
 		c.closeCallback(true)
@@ -56,6 +67,49 @@ func (c *connection) onClose() error {
 	return nil
 }
 
+// onRdHup means the writing side is shut down by the peer.
+func (c *connection) onRdHup(p Poll) error {
+	if c.lock(inputShutdown) {
+		c.triggerRead()
+	}
+	return nil
+}
+
+// closeCause records the reason and error of closing.
+type closeCause struct {
+	reason CloseReason
+	err    error
+}
+
+// hupCause returns the cause of closing by poller.
+func (c *connection) hupCause() *closeCause {
+	var err = c.operator.getHupErr()
+	if serr, ok := err.(*os.SyscallError); ok {
+		switch serr.Syscall {
+		case "readv":
+			// the connection is reset by the peer.
+			if serr.Err == syscall.ECONNRESET {
+				return &closeCause{reason: CloseReasonHangup, err: err}
+			}
+			return &closeCause{reason: CloseReasonReadError, err: err}
+		case "sendmsg":
+			return &closeCause{reason: CloseReasonWriteError, err: err}
+		}
+	}
+	// check the pending socket error, such as ECONNRESET.
+	if n, _ := syscall.GetsockoptInt(c.fd, syscall.SOL_SOCKET, syscall.SO_ERROR); n != 0 {
+		err = syscall.Errno(n)
+	}
+	return &closeCause{reason: CloseReasonHangup, err: err}
+}
+
+// resetFlushCause forgets the latest flush failure, since the connection has been flushed successfully after it.
+func (c *connection) resetFlushCause() {
+	if fc, _ := c.flushCause.Load().(*closeCause); fc != nil {
+		c.flushCause.Store((*closeCause)(nil))
+	}
+}
+
 // closeBuffer recycle input & output LinkBuffer.
 func (c *connection) closeBuffer() {
 	var onConnect, _ = c.onConnectCallback.Load().(OnConnect)
@@ -65,29 +119,147 @@ func (c *connection) closeBuffer() {
 	if c.inputBuffer.Len() == 0 || onConnect != nil || onRequest != nil {
 		c.inputBuffer.Close()
 		barrierPool.Put(c.inputBarrier)
+	} else {
+		runtime.SetFinalizer(c.inputBuffer, (*LinkBuffer).forget)
 	}
 	if c.outputBuffer.Len() == 0 || onConnect != nil || onRequest != nil {
 		c.outputBuffer.Close()
 		barrierPool.Put(c.outputBarrier)
+	} else {
+		runtime.SetFinalizer(c.outputBuffer, (*LinkBuffer).forget)
 	}
 }
 
 // inputs implements FDOperator.
 func (c *connection) inputs(vs [][]byte) (rs [][]byte) {
+	if c.operator.isReadPaused() {
+		// the socket is read by SpliceTo, which is notified to read instead.
+		if atomic.LoadInt32(&c.operator.pause) == 2 {
+			c.operator.Control(pollPauseRead)
+			c.triggerRead()
+		}
+		return vs[:0]
+	}
+	if c.memHold() {
+		return vs[:0]
+	}
+	if c.bufferPolicy.ShrinkAfterIdle > 0 {
+		c.shrinkIdle()
+	}
+	if c.unixMsg != nil && c.unixMsg.message {
+		vs[0] = c.bookMessage()
+		return vs[:1]
+	}
 	vs[0] = c.inputBuffer.book(c.bookSize, c.maxSize)
 	return vs[:1]
 }
 
+// shrinkIdle resets bookSize and maxSize if the connection has been idle for BufferPolicy.ShrinkAfterIdle.
+func (c *connection) shrinkIdle() {
+	var idle = time.Now().UnixNano() - atomic.LoadInt64(&c.stats.lastActive)
+	if idle >= int64(c.bufferPolicy.ShrinkAfterIdle) {
+		c.bookSize, c.maxSize = c.bufferPolicy.InitBookSize, c.bufferPolicy.InitBufferSize
+	}
+}
+
+// initShrink creates the stopped shrinkTimer, which is never replaced so that it can be stopped when closing.
+func (c *connection) initShrink() {
+	if c.bufferPolicy.ShrinkAfterIdle > 0 {
+		c.shrinkTimer = time.AfterFunc(c.bufferPolicy.ShrinkAfterIdle, c.onShrink)
+		c.shrinkTimer.Stop()
+	}
+}
+
+// armShrink starts shrinkTimer after reading, if it is not pending.
+func (c *connection) armShrink() {
+	if !atomic.CompareAndSwapInt32(&c.shrinkArmed, 0, 1) {
+		return
+	}
+	c.resetShrink(c.bufferPolicy.ShrinkAfterIdle)
+}
+
+// resetShrink restarts shrinkTimer, and stops it again if the connection is closed meanwhile.
+func (c *connection) resetShrink(d time.Duration) {
+	c.shrinkTimer.Reset(d)
+	if atomic.LoadInt32(&c.shrinkArmed) == 2 {
+		c.shrinkTimer.Stop()
+	}
+}
+
+// stopShrink stops shrinkTimer when closing, which keeps the connection reachable until it fires.
+func (c *connection) stopShrink() {
+	if c.shrinkTimer != nil && atomic.SwapInt32(&c.shrinkArmed, 2) != 2 {
+		c.shrinkTimer.Stop()
+	}
+}
+
+// onShrink trims inputBuffer if the connection has been idle for BufferPolicy.ShrinkAfterIdle,
+// otherwise waits for the rest of the duration.
+func (c *connection) onShrink() {
+	var idle = time.Duration(time.Now().UnixNano() - atomic.LoadInt64(&c.stats.lastActive))
+	if remain := c.bufferPolicy.ShrinkAfterIdle - idle; remain > 0 && c.IsActive() {
+		c.resetShrink(remain)
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&c.shrinkArmed, 1, 0) || !c.IsActive() {
+		return
+	}
+	// only the connections with OnRequest are trimmed, because the data is never read outside OnRequest,
+	// which is excluded by the processing lock like onProcess.
+	if _, ok := c.onRequestCallback.Load().(OnRequest); !ok {
+		return
+	}
+	// retry later if OnRequest is running.
+	if !c.lock(processing) {
+		c.armShrink()
+		return
+	}
+	// c.operator.do excludes the poller reading.
+	var trimmed = c.operator.do()
+	if trimmed {
+		c.inputBuffer.trim()
+		c.operator.done()
+	}
+	// Handling callback if connection has been closed.
+	if !c.IsActive() {
+		c.closeCallback(false)
+		return
+	}
+	c.unlock(processing)
+	if !trimmed {
+		c.armShrink()
+	}
+	// the data arrived while holding the processing lock is not processed by the poller.
+	if c.inputBuffer.Len() > 0 {
+		c.onRequest()
+	}
+}
+
 // inputAck implements FDOperator.
 func (c *connection) inputAck(n int) (err error) {
+	c.stats.onRead(n)
+	if c.unixMsg != nil && c.unixMsg.message {
+		if n > 0 {
+			c.unixMsg.ackMessage(n)
+		} else if n == 0 && !peerShutdown(c.fd) {
+			// the empty message is read by ReadMessage, but it does not trigger OnRequest without data.
+			c.unixMsg.ackMessage(0)
+			c.inputBuffer.bookAck(0)
+			c.triggerRead()
+			return nil
+		}
+	}
 	if n <= 0 {
 		c.inputBuffer.bookAck(0)
 		return nil
 	}
+	if c.bufferPolicy.ShrinkAfterIdle > 0 {
+		c.armShrink()
+	}
 
 	// Auto size bookSize.
-	if n == c.bookSize && c.bookSize < mallocMax {
-		c.bookSize <<= 1
+	if n == c.bookSize {
+		c.bookSize = c.bufferPolicy.grow(c.bookSize)
 	}
 
 	length, _ := c.inputBuffer.bookAck(n)
@@ -120,6 +292,7 @@ func (c *connection) outputs(vs [][]byte) (rs [][]byte, supportZeroCopy bool) {
 
 // outputAck implements FDOperator.
 func (c *connection) outputAck(n int) (err error) {
+	c.stats.onWrite(n)
 	if n > 0 {
 		c.outputBuffer.Skip(n)
 		c.outputBuffer.Release()
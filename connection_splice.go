@@ -0,0 +1,255 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"errors"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+var (
+	_ io.WriterTo   = &connection{}
+	_ io.ReaderFrom = &connection{}
+)
+
+// maxSendfileSize is the maximum bytes sent by a sendfile call, which is also the limit of Linux.
+const maxSendfileSize = 1<<31 - 4096
+
+// WriteFile implements Connection.
+func (c *connection) WriteFile(f *os.File, off, n int64) (written int64, err error) {
+	if n <= 0 {
+		return 0, nil
+	}
+	if !c.IsActive() || !c.isUnlock(outputShutdown) {
+		return 0, Exception(ErrConnClosed, "when write file")
+	}
+	// the raw fd keeps f open and in its mode while sending, which is not ensured by f.Fd().
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return 0, Exception(err, "when write file")
+	}
+	var rerr = rc.Read(func(src uintptr) bool {
+		var m int
+		for written < n && err == nil {
+			m, err = c.sendfile(int(src), &off, n-written)
+			written += int64(m)
+		}
+		return true
+	})
+	if err == nil && rerr != nil {
+		err = Exception(rerr, "when write file")
+	}
+	return written, err
+}
+
+// sendfile sends a chunk of the file after the buffered data, and waits until writable if the socket is full.
+// The flushing is locked for each chunk instead of the whole file, so Flush is not blocked by a large file.
+func (c *connection) sendfile(src int, off *int64, size int64) (m int, err error) {
+	if !c.lockFlushing() {
+		return 0, Exception(ErrConnClosed, "when write file")
+	}
+	defer c.unlock(flushing)
+	// keep the order with the buffered data
+	c.outputBuffer.Flush()
+	if err = c.flush(); err != nil {
+		return 0, err
+	}
+	if size > maxSendfileSize {
+		size = maxSendfileSize
+	}
+	m, err = syscall.Sendfile(c.fd, src, off, int(size))
+	if m < 0 {
+		m = 0
+	}
+	c.stats.onWrite(m)
+	switch {
+	case err == syscall.EAGAIN:
+		// wait until writable, the poller triggers write immediately since the output buffer is empty.
+		if err = c.operator.Control(PollR2RW); err != nil {
+			return m, Exception(err, "when write file")
+		}
+		return m, c.waitFlush()
+	case err == syscall.EINTR:
+		return m, nil
+	case err != nil:
+		c.flushCause.Store(&closeCause{reason: CloseReasonWriteError, err: err})
+		return m, Exception(err, "when write file")
+	case m == 0:
+		return 0, Exception(ErrEOF, "when write file")
+	}
+	return m, nil
+}
+
+// lockFlushing locks the flushing for a chunk of WriteFile or SpliceTo, and waits if it is locked by Flush.
+// It returns false if the connection is closed or its output is shut down.
+func (c *connection) lockFlushing() bool {
+	for !c.lock(flushing) {
+		if !c.IsActive() || !c.isUnlock(outputShutdown) {
+			return false
+		}
+		runtime.Gosched()
+	}
+	if !c.IsActive() || !c.isUnlock(outputShutdown) {
+		c.unlock(flushing)
+		return false
+	}
+	return true
+}
+
+// SpliceTo implements Connection.
+func (c *connection) SpliceTo(dst Connection, n int64) (written int64, err error) {
+	if n <= 0 {
+		return 0, nil
+	}
+	if d := netpollConn(dst); d != nil {
+		return c.spliceTo(d, n)
+	}
+	return c.copyTo(dst, n)
+}
+
+// WriteTo implements io.WriterTo, which moves the data of the connection to w until EOF.
+// If w is a connection created by netpoll, the data is moved like SpliceTo,
+// otherwise the nodes of the input buffer are written to w directly.
+func (c *connection) WriteTo(w io.Writer) (n int64, err error) {
+	if wc, ok := w.(Connection); ok && c.IsActive() {
+		if d := netpollConn(wc); d != nil {
+			n, err = c.spliceTo(d, math.MaxInt64)
+			if c.readEOF(err) {
+				err = nil
+			}
+			return n, err
+		}
+	}
+	for {
+		if err = c.waitRead(1); err != nil {
+			if c.readEOF(err) {
+				err = nil
+			}
+			return n, err
+		}
+		// released by connection.Release, which resets the tail node of the input buffer.
+		m, err := writeTo(c, w)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// ReadFrom implements io.ReaderFrom, which writes the data from r to the connection until EOF.
+// If r is a regular file, the data is sent by sendfile like WriteFile,
+// and if r is a connection created by netpoll, the data is moved like SpliceTo.
+func (c *connection) ReadFrom(r io.Reader) (n int64, err error) {
+	switch src := r.(type) {
+	case *os.File:
+		if n, ok, err := c.readFile(src); ok {
+			return n, err
+		}
+	case Connection:
+		if s := netpollConn(src); s != nil && s.IsActive() {
+			n, err = s.spliceTo(c, math.MaxInt64)
+			if s.readEOF(err) {
+				err = nil
+			}
+			return n, err
+		}
+	}
+	return readFrom(c, r)
+}
+
+// readFile sends f from the current offset to the end by WriteFile, and advances the offset.
+// It returns ok == false if f is not a regular file.
+func (c *connection) readFile(f *os.File) (n int64, ok bool, err error) {
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return 0, false, nil
+	}
+	off, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false, nil
+	}
+	n, err = c.WriteFile(f, off, info.Size()-off)
+	if _, serr := f.Seek(off+n, io.SeekStart); err == nil {
+		err = serr
+	}
+	// the file is truncated while sending.
+	if errors.Is(err, ErrEOF) {
+		err = nil
+	}
+	return n, true, err
+}
+
+// readEOF reports whether err means that no more data can be read from the peer.
+func (c *connection) readEOF(err error) bool {
+	if errors.Is(err, ErrEOF) {
+		return true
+	}
+	reason, _ := c.CloseReason()
+	return errors.Is(err, ErrConnClosed) && reason == CloseReasonHangup
+}
+
+// netpollConn returns the underlying connection of conn if it is created by netpoll.
+func netpollConn(conn Connection) *connection {
+	switch c := conn.(type) {
+	case *connection:
+		return c
+	case *TCPConnection:
+		return &c.connection
+	case *UnixConnection:
+		return &c.connection
+	}
+	return nil
+}
+
+// copyTo copies the next n bytes to dst in userspace.
+func (c *connection) copyTo(dst Connection, n int64) (written int64, err error) {
+	var w = dst.Writer()
+	for written < n {
+		if err = c.waitRead(1); err != nil {
+			return written, err
+		}
+		written += c.copyBuffered(w, n-written)
+		if err = w.Flush(); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// copyBuffered copies at most n bytes of the input buffer to w without flushing,
+// and releases them, since w may not be flushed before the input buffer is reused.
+func (c *connection) copyBuffered(w Writer, n int64) (written int64) {
+	var m = c.inputBuffer.Len()
+	if int64(m) > n {
+		m = int(n)
+	}
+	if m == 0 {
+		return 0
+	}
+	vec, _ := c.inputBuffer.NextVec(m)
+	buf, _ := w.Malloc(m)
+	for _, p := range vec {
+		buf = buf[copy(buf, p):]
+	}
+	c.inputBuffer.Release()
+	return int64(m)
+}
@@ -0,0 +1,147 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"syscall"
+	"time"
+)
+
+// spliceTo moves the bytes in the input buffer to d at first, and then splices the rest from the socket
+// through a pipe, while the poller stops reading the socket and only notifies readable.
+// The flushing of d is locked for each chunk moved by the pipe instead of the whole transfer.
+func (c *connection) spliceTo(d *connection, n int64) (written int64, err error) {
+	if !c.IsActive() || !d.IsActive() || !d.isUnlock(outputShutdown) {
+		return 0, Exception(ErrConnClosed, "when splice")
+	}
+	if err = c.operator.Control(pollPauseRead); err != nil {
+		return 0, Exception(err, "when splice")
+	}
+	defer c.operator.Control(pollResumeRead)
+	// the data may be read by the poller before pausing.
+	c.operator.waitHandled()
+	if !d.lockFlushing() {
+		return 0, Exception(ErrConnClosed, "when splice")
+	}
+	written = c.copyBuffered(d.outputBuffer, n)
+	d.outputBuffer.Flush()
+	err = d.flush()
+	d.unlock(flushing)
+	if err != nil || written == n {
+		return written, err
+	}
+	if !c.isUnlock(inputShutdown) {
+		return written, Exception(ErrEOF, "when splice")
+	}
+
+	p, err := getSplicePipe()
+	if err != nil {
+		return written, Exception(err, "when splice")
+	}
+	defer putSplicePipe(p)
+	var m int
+	for written < n {
+		// socket to pipe
+		var size = p.size
+		if int64(size) > n-written {
+			size = int(n - written)
+		}
+		m, err = splice(c.fd, p.wfd, size)
+		switch {
+		case err == syscall.EAGAIN:
+			if err = c.waitSplice(); err != nil {
+				return written, err
+			}
+			continue
+		case err != nil:
+			return written, Exception(err, "when splice")
+		case m == 0:
+			return written, Exception(ErrEOF, "when splice")
+		}
+		p.data = m
+		c.stats.onRead(m)
+		// pipe to socket
+		m, err = d.splicePipe(p)
+		written += int64(m)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// splicePipe moves all the data in the pipe to the socket after the buffered data,
+// and waits until writable if the socket is full.
+func (c *connection) splicePipe(p *splicePipe) (written int, err error) {
+	if !c.lockFlushing() {
+		return 0, Exception(ErrConnClosed, "when splice")
+	}
+	defer c.unlock(flushing)
+	// keep the order with the data written between the chunks.
+	c.outputBuffer.Flush()
+	if err = c.flush(); err != nil {
+		return 0, err
+	}
+	var m int
+	for p.data > 0 {
+		m, err = splice(p.rfd, c.fd, p.data)
+		switch {
+		case err == syscall.EAGAIN:
+			// wait until writable, the poller triggers write immediately since the output buffer is empty.
+			if err = c.operator.Control(PollR2RW); err != nil {
+				return written, Exception(err, "when splice")
+			}
+			if err = c.waitFlush(); err != nil {
+				return written, err
+			}
+			continue
+		case err != nil:
+			c.flushCause.Store(&closeCause{reason: CloseReasonWriteError, err: err})
+			return written, Exception(err, "when splice")
+		}
+		p.data -= m
+		written += m
+		c.stats.onWrite(m)
+	}
+	return written, nil
+}
+
+// waitSplice waits until the socket is readable or the read timeout.
+func (c *connection) waitSplice() (err error) {
+	if err = c.operator.Control(pollNotifyRead); err != nil {
+		return Exception(err, "when splice")
+	}
+	if c.readTimeout <= 0 {
+		<-c.readTrigger
+	} else {
+		if c.readTimer == nil {
+			c.readTimer = time.NewTimer(c.readTimeout)
+		} else {
+			c.readTimer.Reset(c.readTimeout)
+		}
+		select {
+		case <-c.readTrigger:
+			if !c.readTimer.Stop() {
+				<-c.readTimer.C
+			}
+		case <-c.readTimer.C:
+			return Exception(ErrReadTimeout, c.remoteAddr.String())
+		}
+	}
+	if !c.IsActive() {
+		return Exception(ErrConnClosed, "when splice")
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestConnectionWriteFile(t *testing.T) {
+	var data = make([]byte, 3*1024*1024)
+	rand.Read(data)
+	var path = filepath.Join(t.TempDir(), "file")
+	MustNil(t, ioutil.WriteFile(path, data, 0644))
+	f, err := os.Open(path)
+	MustNil(t, err)
+	defer f.Close()
+
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, nil)
+	wconn.init(&netFD{fd: w}, nil)
+	defer rconn.Close()
+	defer wconn.Close()
+
+	var received = make(chan []byte, 1)
+	go func() {
+		buf, err := rconn.Reader().Next(len(data) - 10 + 4)
+		MustNil(t, err)
+		received <- buf
+	}()
+	// the buffered data is sent before the file.
+	_, err = wconn.Writer().WriteString("head")
+	MustNil(t, err)
+	n, err := wconn.WriteFile(f, 10, int64(len(data)-10))
+	MustNil(t, err)
+	Equal(t, int(n), len(data)-10)
+	buf := <-received
+	Equal(t, string(buf[:4]), "head")
+	MustTrue(t, bytes.Equal(buf[4:], data[10:]))
+	Equal(t, int(wconn.Stats().BytesWritten), len(buf))
+
+	// the file is shorter than expected
+	go rconn.Reader().Next(5)
+	n, err = wconn.WriteFile(f, int64(len(data)-5), 10)
+	Equal(t, int(n), 5)
+	MustTrue(t, errors.Is(err, ErrEOF))
+}
+
+func TestConnectionSpliceTo(t *testing.T) {
+	var data = make([]byte, 3*1024*1024)
+	rand.Read(data)
+
+	// peer -> src -> dst -> sink
+	r1, w1 := GetSysFdPairs()
+	r2, w2 := GetSysFdPairs()
+	var peer, src, dst, sink = &connection{}, &connection{}, &connection{}, &connection{}
+	peer.init(&netFD{fd: w1}, nil)
+	src.init(&netFD{fd: r1}, nil)
+	dst.init(&netFD{fd: w2}, nil)
+	sink.init(&netFD{fd: r2}, nil)
+	defer src.Close()
+	defer dst.Close()
+	defer sink.Close()
+
+	// the data read before splicing is moved as well.
+	_, err := peer.WriteBinary(data[:100])
+	MustNil(t, err)
+	MustNil(t, peer.Flush())
+	_, err = src.Reader().Peek(100)
+	MustNil(t, err)
+	go func() {
+		for i := 100; i < len(data); i += 64 * 1024 {
+			end := i + 64*1024
+			if end > len(data) {
+				end = len(data)
+			}
+			peer.WriteBinary(data[i:end])
+			peer.Flush()
+		}
+		peer.WriteString("tail")
+		peer.Flush()
+	}()
+	var received = make(chan []byte, 1)
+	go func() {
+		buf, err := sink.Reader().Next(len(data))
+		MustNil(t, err)
+		received <- buf
+	}()
+	n, err := src.SpliceTo(dst, int64(len(data)))
+	MustNil(t, err)
+	Equal(t, int(n), len(data))
+	MustTrue(t, bytes.Equal(<-received, data))
+
+	// the poller reads the rest after splicing.
+	buf, err := src.Reader().Next(4)
+	MustNil(t, err)
+	Equal(t, string(buf), "tail")
+
+	// the data is copied if dst is not created by netpoll.
+	peer.WriteString("copy")
+	peer.Flush()
+	n, err = src.SpliceTo(struct{ Connection }{dst}, 4)
+	MustNil(t, err)
+	Equal(t, int(n), 4)
+	buf, err = sink.Reader().Next(4)
+	MustNil(t, err)
+	Equal(t, string(buf), "copy")
+
+	// closed by the peer
+	peer.WriteString("eof")
+	peer.Flush()
+	peer.Close()
+	n, err = src.SpliceTo(dst, 10)
+	Equal(t, int(n), 3)
+	MustTrue(t, errors.Is(err, ErrEOF))
+}
+
+func TestConnectionSpliceToFlush(t *testing.T) {
+	r1, w1 := GetSysFdPairs()
+	r2, w2 := GetSysFdPairs()
+	var peer, src, dst, sink = &connection{}, &connection{}, &connection{}, &connection{}
+	peer.init(&netFD{fd: w1}, nil)
+	src.init(&netFD{fd: r1}, nil)
+	dst.init(&netFD{fd: w2}, nil)
+	sink.init(&netFD{fd: r2}, nil)
+	defer peer.Close()
+	defer src.Close()
+	defer dst.Close()
+	defer sink.Close()
+
+	var spliced = make(chan error, 1)
+	go func() {
+		_, err := src.SpliceTo(dst, 5)
+		spliced <- err
+	}()
+	// dst can be flushed while SpliceTo is waiting for the data.
+	time.Sleep(20 * time.Millisecond)
+	_, err := dst.WriteString("head")
+	MustNil(t, err)
+	MustNil(t, dst.Flush())
+	_, err = peer.WriteString("hello")
+	MustNil(t, err)
+	MustNil(t, peer.Flush())
+	MustNil(t, <-spliced)
+	buf, err := sink.Reader().Next(9)
+	MustNil(t, err)
+	Equal(t, string(buf), "headhello")
+}
+
+func TestConnectionIOCopy(t *testing.T) {
+	var data = make([]byte, 1024*1024)
+	rand.Read(data)
+	var path = filepath.Join(t.TempDir(), "file")
+	MustNil(t, ioutil.WriteFile(path, data, 0644))
+	f, err := os.Open(path)
+	MustNil(t, err)
+	defer f.Close()
+
+	// file -> peer -> src -> dst -> sink
+	r1, w1 := GetSysFdPairs()
+	r2, w2 := GetSysFdPairs()
+	var peer, src, dst, sink = &connection{}, &connection{}, &connection{}, &connection{}
+	peer.init(&netFD{fd: w1}, nil)
+	src.init(&netFD{fd: r1}, nil)
+	dst.init(&netFD{fd: w2}, nil)
+	sink.init(&netFD{fd: r2}, nil)
+	defer peer.Close()
+	defer src.Close()
+	defer dst.Close()
+	defer sink.Close()
+
+	// the file is sent from the current offset.
+	_, err = f.Seek(10, io.SeekStart)
+	MustNil(t, err)
+	go func() {
+		n, err := io.Copy(peer, f)
+		MustNil(t, err)
+		Equal(t, int(n), len(data)-10)
+		peer.CloseWrite()
+	}()
+	var received = make(chan []byte, 1)
+	go func() {
+		buf, err := sink.Reader().Next(len(data) - 10)
+		MustNil(t, err)
+		received <- buf
+	}()
+	n, err := io.Copy(dst, src)
+	MustNil(t, err)
+	Equal(t, int(n), len(data)-10)
+	MustTrue(t, bytes.Equal(<-received, data[10:]))
+	off, err := f.Seek(0, io.SeekCurrent)
+	MustNil(t, err)
+	Equal(t, int(off), len(data))
+
+	// the data is written to io.Writer until EOF.
+	_, err = dst.WriteBinary(data[:100])
+	MustNil(t, err)
+	MustNil(t, dst.Flush())
+	dst.Close()
+	var w bytes.Buffer
+	n, err = sink.WriteTo(&w)
+	MustNil(t, err)
+	Equal(t, int(n), 100)
+	MustTrue(t, bytes.Equal(w.Bytes(), data[:100]))
+}
+
+func TestConnectionWriteToRelease(t *testing.T) {
+	r, w := GetSysFdPairs()
+	var rconn, wconn = &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, nil)
+	wconn.init(&netFD{fd: w}, nil)
+	defer rconn.Close()
+
+	var data = make([]byte, 16*pagesize)
+	rand.Read(data)
+	_, err := wconn.WriteBinary(data)
+	MustNil(t, err)
+	MustNil(t, wconn.Flush())
+	var pr, pw = io.Pipe()
+	go func() {
+		rconn.WriteTo(pw)
+		pw.Close()
+	}()
+	var received = make([]byte, len(data))
+	_, err = io.ReadFull(pr, received)
+	MustNil(t, err)
+	MustTrue(t, bytes.Equal(received, data))
+	// the large tail node is reset by connection.Release after writing
+	var size = func() int {
+		for !rconn.operator.do() {
+			runtime.Gosched()
+		}
+		defer rconn.operator.done()
+		return cap(rconn.inputBuffer.write.buf)
+	}
+	var deadline = time.Now().Add(time.Second)
+	for size() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	Equal(t, size(), 0)
+	MustNil(t, wconn.Close())
+}
+
+func TestConnectionIOReaderCopy(t *testing.T) {
+	r, w := GetSysFdPairs()
+	var rconn, wconn = &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, nil)
+	wconn.init(&netFD{fd: w}, nil)
+	defer rconn.Close()
+	defer wconn.Close()
+
+	_, err := wconn.Write([]byte("hello"))
+	MustNil(t, err)
+	for rconn.Reader().Len() < 5 {
+		runtime.Gosched()
+	}
+	var done = make(chan struct{})
+	var buf bytes.Buffer
+	go func() {
+		defer close(done)
+		n, err := io.Copy(&buf, newIOReader(rconn))
+		MustNil(t, err)
+		Equal(t, int(n), 5)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("io.Copy waits for EOF")
+	}
+	Equal(t, buf.String(), "hello")
+}
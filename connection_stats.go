@@ -0,0 +1,79 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// connStats records the traffic of a connection, all fields are updated atomically.
+type connStats struct {
+	createdAt    int64 // unix nano
+	lastActive   int64 // unix nano
+	bytesRead    int64
+	bytesWritten int64
+	readCalls    int64
+	writeCalls   int64
+	writeStalls  int64
+}
+
+func (s *connStats) init() {
+	var now = time.Now().UnixNano()
+	atomic.StoreInt64(&s.createdAt, now)
+	atomic.StoreInt64(&s.lastActive, now)
+}
+
+// onRead records a read syscall which returns n bytes.
+func (s *connStats) onRead(n int) {
+	atomic.AddInt64(&s.readCalls, 1)
+	if n > 0 {
+		atomic.AddInt64(&s.bytesRead, int64(n))
+		atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+	}
+}
+
+// onWrite records a write syscall which returns n bytes.
+func (s *connStats) onWrite(n int) {
+	atomic.AddInt64(&s.writeCalls, 1)
+	if n > 0 {
+		atomic.AddInt64(&s.bytesWritten, int64(n))
+		atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+	}
+}
+
+// onWriteStall records waiting for writable.
+func (s *connStats) onWriteStall() {
+	atomic.AddInt64(&s.writeStalls, 1)
+}
+
+// Stats implements Connection.
+func (c *connection) Stats() (stats ConnectionStats) {
+	stats.CreatedAt = time.Unix(0, atomic.LoadInt64(&c.stats.createdAt))
+	stats.LastActive = time.Unix(0, atomic.LoadInt64(&c.stats.lastActive))
+	stats.BytesRead = atomic.LoadInt64(&c.stats.bytesRead)
+	stats.BytesWritten = atomic.LoadInt64(&c.stats.bytesWritten)
+	stats.ReadCalls = atomic.LoadInt64(&c.stats.readCalls)
+	stats.WriteCalls = atomic.LoadInt64(&c.stats.writeCalls)
+	stats.WriteStalls = atomic.LoadInt64(&c.stats.writeStalls)
+	stats.InputLen = c.inputBuffer.Len()
+	stats.OutputLen = c.outputBuffer.Len()
+	stats.InputCap = c.inputBuffer.totalCap()
+	stats.OutputCap = c.outputBuffer.totalCap()
+	return stats
+}
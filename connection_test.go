@@ -22,6 +22,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"net"
 	"runtime"
 	"sync"
 	"sync/atomic"
@@ -432,3 +433,471 @@ func TestBookSizeLargerThanMaxSize(t *testing.T) {
 	wg.Wait()
 	rconn.Close()
 }
+
+func TestConnectionHalfClose(t *testing.T) {
+	r, w := GetSysFdPairs()
+	var rconn = &connection{}
+	rconn.init(&netFD{fd: r}, &options{halfClose: true})
+	defer syscall.Close(w)
+
+	var msg = []byte("hello")
+	syscall.Write(w, msg)
+	syscall.Shutdown(w, syscall.SHUT_WR)
+
+	buf, err := rconn.Reader().Next(len(msg))
+	MustNil(t, err)
+	Equal(t, string(buf), string(msg))
+	_, err = rconn.Reader().Next(1)
+	MustTrue(t, errors.Is(err, ErrEOF))
+	MustTrue(t, rconn.IsActive())
+
+	// the connection is still writable after half-closed by peer
+	n, err := rconn.Write(msg)
+	MustNil(t, err)
+	Equal(t, n, len(msg))
+	var rbuf = make([]byte, len(msg))
+	n, err = syscall.Read(w, rbuf)
+	MustNil(t, err)
+	Equal(t, string(rbuf[:n]), string(msg))
+
+	err = rconn.Close()
+	MustNil(t, err)
+}
+
+func TestConnectionCloseWrite(t *testing.T) {
+	r, w := GetSysFdPairs()
+	var wconn = &connection{}
+	wconn.init(&netFD{fd: w}, nil)
+	defer syscall.Close(r)
+
+	_, err := wconn.Writer().WriteString("hello")
+	MustNil(t, err)
+	err = wconn.CloseWrite()
+	MustNil(t, err)
+	_, err = wconn.Writer().WriteString("world")
+	MustNil(t, err)
+	err = wconn.Writer().Flush()
+	MustTrue(t, errors.Is(err, ErrConnClosed))
+
+	var buf = make([]byte, 16)
+	n, err := syscall.Read(r, buf)
+	MustNil(t, err)
+	Equal(t, string(buf[:n]), "hello")
+	n, err = syscall.Read(r, buf)
+	MustNil(t, err)
+	Equal(t, n, 0)
+
+	// the connection is still readable after CloseWrite
+	syscall.Write(r, []byte("world"))
+	s, err := wconn.Reader().ReadString(5)
+	MustNil(t, err)
+	Equal(t, s, "world")
+	MustTrue(t, wconn.IsActive())
+
+	err = wconn.CloseRead()
+	MustNil(t, err)
+	_, err = wconn.Reader().Next(1)
+	MustTrue(t, errors.Is(err, ErrEOF))
+
+	err = wconn.Close()
+	MustNil(t, err)
+}
+
+func TestConnectionCloseReason(t *testing.T) {
+	// close by user
+	r, w := GetSysFdPairs()
+	var rconn, wconn = &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, nil)
+	wconn.init(&netFD{fd: w}, nil)
+	reason, err := rconn.CloseReason()
+	Equal(t, reason, CloseReasonNone)
+	MustNil(t, err)
+	err = wconn.Close()
+	MustNil(t, err)
+	reason, err = wconn.CloseReason()
+	Equal(t, reason, CloseReasonUser)
+	MustNil(t, err)
+
+	// hangup by peer
+	for rconn.IsActive() {
+		runtime.Gosched()
+	}
+	reason, _ = rconn.CloseReason()
+	Equal(t, reason, CloseReasonHangup)
+	rconn.Close()
+
+	// close after write timeout
+	r, w = GetSysFdPairs()
+	wconn = &connection{}
+	wconn.init(&netFD{fd: w, remoteAddr: &net.UnixAddr{Net: "unix"}}, nil)
+	defer syscall.Close(r)
+	wconn.SetWriteTimeout(time.Millisecond * 10)
+	_, err = wconn.Writer().Malloc(8 * 1024 * 1024)
+	MustNil(t, err)
+	err = wconn.Writer().Flush()
+	MustTrue(t, errors.Is(err, ErrWriteTimeout))
+	wconn.Close()
+	reason, err = wconn.CloseReason()
+	Equal(t, reason, CloseReasonWriteTimeout)
+	MustTrue(t, errors.Is(err, ErrWriteTimeout))
+
+	// the write timeout is forgotten after flushing successfully
+	r, w = GetSysFdPairs()
+	wconn = &connection{}
+	wconn.init(&netFD{fd: w, remoteAddr: &net.UnixAddr{Net: "unix"}}, nil)
+	defer syscall.Close(r)
+	wconn.SetWriteTimeout(time.Millisecond * 10)
+	_, err = wconn.Writer().Malloc(8 * 1024 * 1024)
+	MustNil(t, err)
+	err = wconn.Writer().Flush()
+	MustTrue(t, errors.Is(err, ErrWriteTimeout))
+	go func() {
+		var buf = make([]byte, 64*1024)
+		for total := 0; total < 8*1024*1024; {
+			n, err := syscall.Read(r, buf)
+			if err != nil && err != syscall.EINTR {
+				return
+			}
+			total += n
+		}
+	}()
+	wconn.SetWriteTimeout(0)
+	MustNil(t, wconn.Writer().Flush())
+	wconn.Close()
+	reason, err = wconn.CloseReason()
+	Equal(t, reason, CloseReasonUser)
+	MustNil(t, err)
+}
+
+func TestConnectionCloseReasonReset(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer ln.Close()
+	var accepted = make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		MustNil(t, err)
+		accepted <- conn
+	}()
+	conn, err := DialConnection("tcp", ln.Addr().String(), time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+
+	// the peer sends RST by closing with SO_LINGER 0.
+	peer := (<-accepted).(*net.TCPConn)
+	MustNil(t, peer.SetLinger(0))
+	MustNil(t, peer.Close())
+	for conn.IsActive() {
+		runtime.Gosched()
+	}
+	reason, err := conn.CloseReason()
+	Equal(t, reason, CloseReasonHangup)
+	MustTrue(t, errors.Is(err, syscall.ECONNRESET))
+}
+
+func TestConnectionAttachment(t *testing.T) {
+	r, w := GetSysFdPairs()
+	var rconn = &connection{}
+	rconn.init(&netFD{fd: r}, nil)
+	defer syscall.Close(w)
+
+	type ctxKey struct{}
+	rconn.Set(ctxKey{}, "value")
+	v, ok := rconn.Get(ctxKey{})
+	MustTrue(t, ok)
+	Equal(t, v, "value")
+	rconn.Set(ctxKey{}, nil)
+	_, ok = rconn.Get(ctxKey{})
+	MustTrue(t, !ok)
+
+	// attachments are still available in CloseCallback, and cleared after closing.
+	var trigger = make(chan interface{}, 1)
+	rconn.Set("key", 1)
+	rconn.AddCloseCallback(func(connection Connection) error {
+		v, _ := connection.Get("key")
+		trigger <- v
+		return nil
+	})
+	err := rconn.Close()
+	MustNil(t, err)
+	Equal(t, <-trigger, 1)
+	_, ok = rconn.Get("key")
+	MustTrue(t, !ok)
+}
+
+func TestConnectionStats(t *testing.T) {
+	r, w := GetSysFdPairs()
+	var rconn, wconn = &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, nil)
+	wconn.init(&netFD{fd: w}, nil)
+	defer rconn.Close()
+	defer wconn.Close()
+
+	var msg = make([]byte, 1024)
+	for i := 0; i < 3; i++ {
+		_, err := wconn.Write(msg)
+		MustNil(t, err)
+	}
+	buf, err := rconn.Reader().Next(3 * len(msg))
+	MustNil(t, err)
+	Equal(t, len(buf), 3*len(msg))
+
+	ws, rs := wconn.Stats(), rconn.Stats()
+	Equal(t, ws.BytesWritten, int64(3*len(msg)))
+	Equal(t, ws.WriteCalls, int64(3))
+	Equal(t, ws.BytesRead, int64(0))
+	Equal(t, rs.BytesRead, int64(3*len(msg)))
+	MustTrue(t, rs.ReadCalls > 0)
+	Equal(t, rs.InputLen, 0)
+	MustTrue(t, !rs.LastActive.Before(rs.CreatedAt))
+	// the data read is held by the input buffer until released.
+	MustTrue(t, rs.InputCap >= 3*len(msg))
+	Equal(t, ws.OutputCap, wconn.outputBuffer.totalCap())
+}
+
+func TestConnectionBinary(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, nil)
+	wconn.init(&netFD{fd: w}, nil)
+	defer rconn.Close()
+	defer wconn.Close()
+
+	MustNil(t, wconn.WriteUint32(0x01020304))
+	MustNil(t, wconn.WriteUint64LE(0x0102030405060708))
+	MustNil(t, wconn.WriteVarint(-1<<40))
+	MustNil(t, wconn.Flush())
+	go func() {
+		// the varint is split by two writes
+		wconn.Write([]byte{0x80, 0x80})
+		time.Sleep(10 * time.Millisecond)
+		wconn.Write([]byte{0x01})
+	}()
+
+	u32, err := rconn.ReadUint32()
+	MustNil(t, err)
+	Equal(t, u32, uint32(0x01020304))
+	u64, err := rconn.Reader().ReadUint64LE()
+	MustNil(t, err)
+	Equal(t, u64, uint64(0x0102030405060708))
+	i64, err := rconn.ReadVarint()
+	MustNil(t, err)
+	Equal(t, i64, int64(-1<<40))
+	u64, err = rconn.ReadUvarint()
+	MustNil(t, err)
+	Equal(t, u64, uint64(1<<14))
+}
+
+func TestConnectionUntilBytes(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, nil)
+	wconn.init(&netFD{fd: w}, nil)
+	defer rconn.Close()
+
+	go func() {
+		// the delimiter is split by two writes
+		wconn.Write([]byte("+OK\r"))
+		time.Sleep(10 * time.Millisecond)
+		wconn.Write([]byte("\n$5\r\nhello\r\n"))
+		wconn.Write(make([]byte, 100))
+		time.Sleep(10 * time.Millisecond)
+		wconn.Close()
+	}()
+
+	var delim = []byte("\r\n")
+	line, err := rconn.Reader().UntilBytes(delim)
+	MustNil(t, err)
+	Equal(t, string(line), "+OK\r\n")
+	line, err = rconn.Reader().UntilBytesLimit(delim, 4)
+	MustNil(t, err)
+	Equal(t, string(line), "$5\r\n")
+	line, err = rconn.Reader().UntilBytesLimit(delim, 7)
+	MustNil(t, err)
+	Equal(t, string(line), "hello\r\n")
+	_, err = rconn.Reader().UntilBytesLimit(delim, 64)
+	MustTrue(t, errors.Is(err, ErrLineTooLong))
+	line, err = rconn.Reader().UntilBytes(delim)
+	Equal(t, len(line), 100)
+	MustTrue(t, errors.Is(err, ErrEOF))
+}
+
+func TestConnectionBufferPolicy(t *testing.T) {
+	var opts = &options{}
+	WithBufferPolicy(BufferPolicy{
+		InitBookSize:    block1k,
+		MaxBookSize:     block4k,
+		GrowthFactor:    4,
+		InitBufferSize:  block2k,
+		ShrinkAfterIdle: 10 * time.Millisecond,
+	}).f(opts)
+	r, w := GetSysFdPairs()
+	var rconn, wconn = &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, opts)
+	wconn.init(&netFD{fd: w}, nil)
+	defer rconn.Close()
+	defer wconn.Close()
+	// the sizes are read while the poller is not handling the connection.
+	var sizes = func() (bookSize, maxSize int) {
+		for !rconn.operator.do() {
+			runtime.Gosched()
+		}
+		defer rconn.operator.done()
+		return rconn.bookSize, rconn.maxSize
+	}
+	bookSize, maxSize := sizes()
+	Equal(t, bookSize, block1k)
+	Equal(t, maxSize, block2k)
+
+	_, err := wconn.Write(make([]byte, 64*block1k))
+	MustNil(t, err)
+	_, err = rconn.Reader().Next(64 * block1k)
+	MustNil(t, err)
+	bookSize, _ = sizes()
+	Equal(t, bookSize, block4k)
+	MustNil(t, rconn.Reader().Release())
+
+	// shrink after idle
+	time.Sleep(20 * time.Millisecond)
+	_, err = wconn.Write(make([]byte, 10))
+	MustNil(t, err)
+	_, err = rconn.Reader().Next(10)
+	MustNil(t, err)
+	bookSize, maxSize = sizes()
+	Equal(t, bookSize, block1k)
+	Equal(t, maxSize, block2k)
+
+	// defaults
+	var policy = BufferPolicy{GrowthFactor: 1, InitBookSize: 2 * mallocMax}.normalize()
+	Equal(t, policy.MaxBookSize, mallocMax)
+	Equal(t, policy.InitBookSize, mallocMax)
+	Equal(t, policy.InitBufferSize, pagesize)
+	Equal(t, policy.grow(block1k), block1k)
+	Equal(t, defaultBufferPolicy.grow(mallocMax/2+1), mallocMax)
+}
+
+func TestConnectionBufferSmall(t *testing.T) {
+	var opts = &options{}
+	WithBufferPolicy(BufferPolicy{InitBookSize: 128, InitBufferSize: 256}).f(opts)
+	r, w := GetSysFdPairs()
+	var rconn, wconn = &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, opts)
+	wconn.init(&netFD{fd: w}, nil)
+	defer rconn.Close()
+	defer wconn.Close()
+
+	// the node is not limited by LinkBufferCap
+	_, err := wconn.Write(make([]byte, 10))
+	MustNil(t, err)
+	_, err = rconn.Reader().Next(10)
+	MustNil(t, err)
+	for !rconn.operator.do() {
+		runtime.Gosched()
+	}
+	var size = cap(rconn.inputBuffer.write.buf)
+	rconn.operator.done()
+	Equal(t, size, 256)
+	MustNil(t, rconn.Reader().Release())
+}
+
+func TestConnectionBufferShrink(t *testing.T) {
+	if debugMode {
+		t.Skip("the released nodes are never reset in debug mode")
+	}
+	var recv = make(chan string, 1)
+	var opts = &options{onRequest: func(ctx context.Context, connection Connection) error {
+		buf, err := connection.Reader().Next(connection.Reader().Len())
+		MustNil(t, err)
+		recv <- string(buf)
+		return connection.Reader().Release()
+	}}
+	WithBufferPolicy(BufferPolicy{ShrinkAfterIdle: 30 * time.Millisecond}).f(opts)
+	r, w := GetSysFdPairs()
+	var rconn, wconn = &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, opts)
+	wconn.init(&netFD{fd: w}, nil)
+	defer rconn.Close()
+	defer wconn.Close()
+
+	var trimmed = func() bool {
+		for !rconn.operator.do() {
+			runtime.Gosched()
+		}
+		defer rconn.operator.done()
+		return rconn.inputBuffer.write.readonly && cap(rconn.inputBuffer.write.buf) == 0
+	}
+	for i := 0; i < 3; i++ {
+		_, err := wconn.WriteString("hello")
+		MustNil(t, err)
+		MustNil(t, wconn.Flush())
+		Equal(t, <-recv, "hello")
+		MustTrue(t, !trimmed())
+		// the buffer is released after idle, and allocated again when data arrives.
+		time.Sleep(100 * time.Millisecond)
+		MustTrue(t, trimmed())
+	}
+}
+
+func TestConnectionBufferShrinkClosed(t *testing.T) {
+	var opts = &options{}
+	WithBufferPolicy(BufferPolicy{ShrinkAfterIdle: time.Hour}).f(opts)
+	r, w := GetSysFdPairs()
+	var rconn, wconn = &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, opts)
+	wconn.init(&netFD{fd: w}, nil)
+	defer wconn.Close()
+
+	_, err := wconn.Write(make([]byte, 10))
+	MustNil(t, err)
+	_, err = rconn.Reader().Next(10)
+	MustNil(t, err)
+	Equal(t, atomic.LoadInt32(&rconn.shrinkArmed), int32(1))
+	// the pending timer is stopped when closing, and it is never armed again.
+	MustNil(t, rconn.Close())
+	MustTrue(t, !rconn.shrinkTimer.Stop())
+	rconn.armShrink()
+	Equal(t, atomic.LoadInt32(&rconn.shrinkArmed), int32(2))
+}
+
+func TestConnectionTryNext(t *testing.T) {
+	r, w := GetSysFdPairs()
+	var rconn, wconn = &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, nil)
+	wconn.init(&netFD{fd: w}, nil)
+	defer rconn.Close()
+
+	_, err := rconn.Reader().TryNext(1)
+	MustTrue(t, errors.Is(err, ErrWouldBlock))
+	_, err = rconn.Reader().TryPeek(1)
+	MustTrue(t, errors.Is(err, ErrWouldBlock))
+
+	_, err = wconn.WriteString("hello world")
+	MustNil(t, err)
+	MustNil(t, wconn.Flush())
+	// PeekAtLeast blocks until min bytes
+	p, err := rconn.Reader().PeekAtLeast(5)
+	MustNil(t, err)
+	MustTrue(t, len(p) >= 5)
+	for rconn.Reader().Len() < 11 {
+		runtime.Gosched()
+	}
+	p, err = rconn.Reader().TryNext(6)
+	MustNil(t, err)
+	Equal(t, string(p), "hello ")
+	p, err = rconn.Reader().TryPeek(5)
+	MustNil(t, err)
+	Equal(t, string(p), "world")
+	_, err = rconn.Reader().TryNext(6)
+	MustTrue(t, errors.Is(err, ErrWouldBlock))
+
+	// no more data arrives after closed
+	MustNil(t, wconn.Close())
+	for rconn.IsActive() {
+		runtime.Gosched()
+	}
+	_, err = rconn.Reader().TryNext(6)
+	MustTrue(t, err != nil && !errors.Is(err, ErrWouldBlock))
+	p, err = rconn.Reader().TryNext(5)
+	MustNil(t, err)
+	Equal(t, string(p), "world")
+}
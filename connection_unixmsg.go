@@ -0,0 +1,256 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// unixMsg holds the ancillary data received by unix connections,
+// and the sizes of the received messages if the connection is message-oriented.
+type unixMsg struct {
+	mu      sync.Mutex
+	closed  bool // the file descriptors received after closing are closed immediately
+	fds     []int
+	cred    *UnixCredentials
+	message bool // unixgram or unixpacket
+	sizes   []int
+}
+
+// initUnixMsg makes the poller receive the control messages of unix connections,
+// and the unread file descriptors are closed when closing.
+func (c *connection) initUnixMsg() {
+	var msg = &unixMsg{message: c.network != "unix"}
+	c.unixMsg = msg
+	c.operator.control = make([]byte, unixControlSize)
+	c.operator.controlAck = func(oob []byte) {
+		fds, cred, _ := parseUnixControl(oob)
+		msg.mu.Lock()
+		if msg.closed {
+			msg.mu.Unlock()
+			closeFds(fds)
+			return
+		}
+		msg.fds = append(msg.fds, fds...)
+		if cred != nil {
+			msg.cred = cred
+		}
+		msg.mu.Unlock()
+	}
+	c.AddCloseCallback(func(connection Connection) error {
+		msg.mu.Lock()
+		msg.closed = true
+		fds := msg.fds
+		msg.fds = nil
+		msg.mu.Unlock()
+		closeFds(fds)
+		return nil
+	})
+}
+
+func closeFds(fds []int) {
+	for _, fd := range fds {
+		syscall.Close(fd)
+	}
+}
+
+func (msg *unixMsg) take() (fds []int) {
+	msg.mu.Lock()
+	fds, msg.fds = msg.fds, nil
+	msg.mu.Unlock()
+	return fds
+}
+
+// bookMessage books the buffer which is large enough to hold the next message.
+func (c *connection) bookMessage() []byte {
+	size, _ := pendingBytes(c.fd)
+	return c.inputBuffer.bookAtLeast(size, c.maxSize)
+}
+
+// ackMessage records the size of the received message, which must be called before the data is visible.
+func (msg *unixMsg) ackMessage(n int) {
+	msg.mu.Lock()
+	msg.sizes = append(msg.sizes, n)
+	msg.mu.Unlock()
+}
+
+// ReadMessage implements UnixConn.
+func (c *UnixConnection) ReadMessage() (p []byte, err error) {
+	if !c.unixMsg.message {
+		return nil, Exception(ErrUnsupported, "ReadMessage of "+c.network)
+	}
+	if err = c.waitMessage(); err != nil {
+		return nil, err
+	}
+	var msg = c.unixMsg
+	msg.mu.Lock()
+	if len(msg.sizes) == 0 {
+		msg.mu.Unlock()
+		return nil, errMessageBoundary
+	}
+	var size = msg.sizes[0]
+	msg.sizes = msg.sizes[1:]
+	msg.mu.Unlock()
+	return c.Next(size)
+}
+
+func (msg *unixMsg) pending() bool {
+	msg.mu.Lock()
+	defer msg.mu.Unlock()
+	return len(msg.sizes) > 0
+}
+
+// waitMessage waits until the next message is received, which may be empty without any data.
+func (c *connection) waitMessage() (err error) {
+	if c.unixMsg.pending() || c.inputBuffer.Len() > 0 {
+		return nil
+	}
+	atomic.StoreInt64(&c.waitReadSize, 1)
+	defer atomic.StoreInt64(&c.waitReadSize, 0)
+	c.memUnhold()
+	var timeout <-chan time.Time
+	if c.readTimeout > 0 {
+		var timer = time.NewTimer(c.readTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for !c.unixMsg.pending() && c.inputBuffer.Len() == 0 {
+		if !c.IsActive() {
+			// the data received before closing is filled by waitRead.
+			return c.waitRead(1)
+		}
+		if !c.isUnlock(inputShutdown) {
+			return Exception(ErrEOF, "wait read")
+		}
+		select {
+		case <-c.readTrigger:
+		case <-timeout:
+			if c.unixMsg.pending() || c.inputBuffer.Len() > 0 {
+				return nil
+			}
+			return Exception(ErrReadTimeout, c.remoteAddr.String())
+		}
+	}
+	return nil
+}
+
+// WriteMessage implements UnixConn.
+func (c *UnixConnection) WriteMessage(b []byte) error {
+	if !c.unixMsg.message {
+		return Exception(ErrUnsupported, "WriteMessage of "+c.network)
+	}
+	return c.writeMsg(b, nil)
+}
+
+var errMessageBoundary = errors.New("message boundary is lost by reading with Reader")
+
+var errUnixMsgEmpty = errors.New("the data sent with the ancillary data must not be empty")
+
+// WriteFds implements UnixConn.
+func (c *UnixConnection) WriteFds(b []byte, fds ...int) error {
+	return c.writeMsg(b, syscall.UnixRights(fds...))
+}
+
+// ReadFds implements UnixConn.
+func (c *UnixConnection) ReadFds() []int {
+	return c.unixMsg.take()
+}
+
+// WriteCredentials implements UnixConn.
+func (c *UnixConnection) WriteCredentials(b []byte, cred *UnixCredentials) error {
+	oob, err := unixCredentials(cred)
+	if err != nil {
+		return err
+	}
+	return c.writeMsg(b, oob)
+}
+
+// ReadCredentials implements UnixConn.
+func (c *UnixConnection) ReadCredentials() (cred *UnixCredentials) {
+	c.unixMsg.mu.Lock()
+	cred, c.unixMsg.cred = c.unixMsg.cred, nil
+	c.unixMsg.mu.Unlock()
+	return cred
+}
+
+// SetPassCred implements UnixConn.
+func (c *UnixConnection) SetPassCred(on bool) error {
+	if err := setPassCred(c.fd, on); err != nil {
+		return Exception(err, "SO_PASSCRED")
+	}
+	return nil
+}
+
+// PeerCredentials implements UnixConn.
+func (c *UnixConnection) PeerCredentials() (*UnixCredentials, error) {
+	cred, err := getPeerCredentials(c.fd)
+	if err != nil {
+		return nil, Exception(err, "SO_PEERCRED")
+	}
+	return cred, nil
+}
+
+// writeMsg sends b with the control message oob after flushing the buffered data,
+// and the rest of b is sent as the normal data if it is partially sent.
+func (c *connection) writeMsg(b, oob []byte) (err error) {
+	if debugMode {
+		debugCheck(b)
+	}
+	// the empty message is allowed without the ancillary data.
+	if len(b) == 0 && (oob != nil || !c.unixMsg.message) {
+		return errUnixMsgEmpty
+	}
+	if !c.IsActive() || !c.isUnlock(outputShutdown) || !c.lock(flushing) {
+		return Exception(ErrConnClosed, "when write msg")
+	}
+	defer c.unlock(flushing)
+	// keep the order with the buffered data
+	c.outputBuffer.Flush()
+	if err = c.flush(); err != nil {
+		return err
+	}
+	var n int
+	for {
+		n, err = syscall.SendmsgN(c.fd, b, oob, nil, 0)
+		if err != syscall.EAGAIN {
+			break
+		}
+		// wait until writable, the poller triggers write immediately since the output buffer is empty.
+		if err = c.operator.Control(PollR2RW); err != nil {
+			return Exception(err, "when write msg")
+		}
+		if err = c.waitFlush(); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		c.flushCause.Store(&closeCause{reason: CloseReasonWriteError, err: err})
+		return Exception(err, "when write msg")
+	}
+	c.stats.onWrite(n)
+	if n == len(b) {
+		return nil
+	}
+	c.outputBuffer.WriteBinary(b[n:])
+	c.outputBuffer.Flush()
+	return c.flush()
+}
@@ -16,6 +16,7 @@ package netpoll
 
 import (
 	"runtime"
+	"sync"
 	"sync/atomic"
 )
 
@@ -30,6 +31,11 @@ type FDOperator struct {
 	OnWrite func(p Poll) error
 	OnHup   func(p Poll) error
 
+	// OnRdHup is optional, which is called instead of OnHup when the peer only shuts down the writing side.
+	// If OnRdHup is set, the poll will stop monitoring readable after all the input data has been read,
+	// but the FDOperator is still writable until hangup.
+	OnRdHup func(p Poll) error
+
 	// The following is the required fn, which must exist when used, or directly panic.
 	// Fns are only called by the poll when handles connection events.
 	Inputs   func(vs [][]byte) (rs [][]byte)
@@ -39,12 +45,29 @@ type FDOperator struct {
 	Outputs   func(vs [][]byte) (rs [][]byte, supportZeroCopy bool)
 	OutputAck func(n int) (err error)
 
+	// control is optional, which makes the poll read by recvmsg with the control buffer instead of readv,
+	// and the received control messages are passed to controlAck before InputAck.
+	control    []byte
+	controlAck func(oob []byte)
+
 	// poll is the registered location of the file descriptor.
 	poll Poll
 
+	// hupErr is the error which makes the poll hang up the FDOperator, such as the readv or sendmsg failure.
+	// It is accessed atomically, because OnHup may be called concurrently with freeing the FDOperator.
+	hupErr atomic.Value // hupError
+
 	// private, used by operatorCache
 	next  *FDOperator
 	state int32 // CAS: 0(unused) 1(inuse) 2(do-done)
+	shut  int32 // 1 means the input side has been shut down, set by PollShutRead
+	pause int32 // 1 means reading is paused by pollPauseRead, 2 means the poll only notifies readable
+	hold  int32 // 1 means reading is held by pollHoldRead because of the memory limit
+
+	// mu guards the registered events of epoll, which are modified by the poll and the connection concurrently.
+	// out is true if writable is monitored.
+	mu  sync.Mutex
+	out bool
 }
 
 func (op *FDOperator) Control(event PollEvent) error {
@@ -81,10 +104,80 @@ func (op *FDOperator) isUnused() bool {
 	return atomic.LoadInt32(&op.state) == 0
 }
 
+func (op *FDOperator) shutRead() {
+	atomic.StoreInt32(&op.shut, 1)
+}
+
+type hupError struct {
+	err error
+}
+
+func (op *FDOperator) setHupErr(err error) {
+	op.hupErr.Store(hupError{err: err})
+}
+
+func (op *FDOperator) getHupErr() error {
+	he, _ := op.hupErr.Load().(hupError)
+	return he.err
+}
+
+func (op *FDOperator) isReadShut() bool {
+	return atomic.LoadInt32(&op.shut) == 1
+}
+
+// setPause records the state changed by pollPauseRead, pollNotifyRead, pollResumeRead, pollHoldRead and pollUnholdRead.
+func (op *FDOperator) setPause(event PollEvent) {
+	switch event {
+	case pollPauseRead:
+		atomic.StoreInt32(&op.pause, 1)
+	case pollNotifyRead:
+		atomic.StoreInt32(&op.pause, 2)
+	case pollResumeRead:
+		atomic.StoreInt32(&op.pause, 0)
+	case pollHoldRead:
+		atomic.StoreInt32(&op.hold, 1)
+	case pollUnholdRead:
+		atomic.StoreInt32(&op.hold, 0)
+	}
+}
+
+// monitorRead reports whether the poll monitors readable and the peer's shutdown of the FDOperator.
+func (op *FDOperator) monitorRead() (readable, rdhup bool) {
+	switch {
+	case op.isReadShut(), atomic.LoadInt32(&op.pause) == 1:
+		return false, false
+	case atomic.LoadInt32(&op.pause) == 2:
+		// the peer's shutdown is read as EOF by the connection itself.
+		return true, false
+	case atomic.LoadInt32(&op.hold) == 1:
+		return false, false
+	}
+	return true, true
+}
+
+func (op *FDOperator) isReadPaused() bool {
+	return atomic.LoadInt32(&op.pause) != 0
+}
+
+// waitHandled waits until the poll finishes handling the events of the FDOperator which are received before.
+func (op *FDOperator) waitHandled() {
+	for atomic.LoadInt32(&op.state) == 2 {
+		runtime.Gosched()
+	}
+}
+
 func (op *FDOperator) reset() {
 	op.FD = 0
-	op.OnRead, op.OnWrite, op.OnHup = nil, nil, nil
+	op.OnRead, op.OnWrite, op.OnHup, op.OnRdHup = nil, nil, nil, nil
+	atomic.StoreInt32(&op.shut, 0)
+	atomic.StoreInt32(&op.pause, 0)
+	atomic.StoreInt32(&op.hold, 0)
+	op.mu.Lock()
+	op.out = false
+	op.mu.Unlock()
 	op.Inputs, op.InputAck = nil, nil
 	op.Outputs, op.OutputAck = nil, nil
+	op.control, op.controlAck = nil, nil
 	op.poll = nil
+	op.setHupErr(nil)
 }
@@ -0,0 +1,165 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mux
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cloudwego/netpoll"
+)
+
+// Codec frames the messages of Conn, and each message carries a sequence ID
+// to match the response with the request.
+type Codec interface {
+	// Encode writes the request msg with the sequence id into w.
+	Encode(w netpoll.Writer, id uint64, msg interface{}) (err error)
+
+	// Decode reads a whole response from r, which can block on r until the data is enough.
+	// The returned msg must not reference the slices of r, which will be released after decoding.
+	// The connection is closed if Decode returns an error.
+	Decode(r netpoll.Reader) (id uint64, msg interface{}, err error)
+}
+
+// Conn multiplexes concurrent request/response calls over a single netpoll.Connection.
+// The requests are merged and sent by ShardQueue, and the responses are dispatched to
+// the pending calls by the sequence IDs decoded by Codec.
+type Conn struct {
+	conn    netpoll.Connection
+	codec   Codec
+	queue   *ShardQueue
+	seq     uint64
+	mu      sync.Mutex
+	pending map[uint64]chan result
+	err     error // not nil after closed
+}
+
+type result struct {
+	msg interface{}
+	err error
+}
+
+// NewConn creates a Conn on conn, which takes over the OnRequest of conn.
+func NewConn(conn netpoll.Connection, codec Codec) *Conn {
+	c := &Conn{
+		conn:    conn,
+		codec:   codec,
+		queue:   NewShardQueue(ShardSize, conn),
+		pending: make(map[uint64]chan result),
+	}
+	conn.AddCloseCallback(func(connection netpoll.Connection) error {
+		c.failAll(netpoll.Exception(netpoll.ErrConnClosed, "mux conn"))
+		return nil
+	})
+	conn.SetOnRequest(c.onRequest)
+	// the connection may be closed before adding the callback.
+	if !conn.IsActive() {
+		c.failAll(netpoll.Exception(netpoll.ErrConnClosed, "mux conn"))
+	}
+	return c
+}
+
+// Call sends the request msg and waits for the response until ctx is done,
+// so the deadline of ctx is the timeout of the call.
+// The late response of a timed out call is discarded.
+func (c *Conn) Call(ctx context.Context, msg interface{}) (resp interface{}, err error) {
+	id := atomic.AddUint64(&c.seq, 1)
+	done := make(chan result, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		err = c.err
+		c.mu.Unlock()
+		return nil, err
+	}
+	c.pending[id] = done
+	c.mu.Unlock()
+
+	buf := netpoll.NewLinkBuffer()
+	if err = c.codec.Encode(buf, id, msg); err != nil {
+		c.remove(id)
+		buf.Close()
+		return nil, err
+	}
+	c.queue.Add(func() (netpoll.Writer, bool) {
+		return buf, false
+	})
+
+	select {
+	case res := <-done:
+		return res.msg, res.err
+	case <-ctx.Done():
+		c.remove(id)
+		return nil, ctx.Err()
+	}
+}
+
+// Pending returns the number of the calls waiting for responses.
+func (c *Conn) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// IsActive reports whether the underlying connection is active.
+func (c *Conn) IsActive() bool {
+	return c.conn.IsActive()
+}
+
+// Close closes the underlying connection, and all the pending calls fail.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Conn) onRequest(ctx context.Context, connection netpoll.Connection) error {
+	reader := connection.Reader()
+	for reader.Len() > 0 {
+		id, msg, err := c.codec.Decode(reader)
+		reader.Release()
+		if err != nil {
+			connection.Close()
+			return err
+		}
+		c.mu.Lock()
+		done, ok := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if ok {
+			done <- result{msg: msg}
+		}
+	}
+	return nil
+}
+
+func (c *Conn) remove(id uint64) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+func (c *Conn) failAll(err error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return
+	}
+	c.err = err
+	pending := c.pending
+	c.pending = make(map[uint64]chan result)
+	c.mu.Unlock()
+	for _, done := range pending {
+		done <- result{err: err}
+	}
+}
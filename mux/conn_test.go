@@ -0,0 +1,168 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package mux
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+// testCodec frames the message as: id(8 bytes) + length(4 bytes) + payload.
+type testCodec struct{}
+
+func (testCodec) Encode(w netpoll.Writer, id uint64, msg interface{}) error {
+	s, ok := msg.(string)
+	if !ok {
+		return errors.New("unsupported message")
+	}
+	buf, err := w.Malloc(12)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint64(buf, id)
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(s)))
+	_, err = w.WriteString(s)
+	return err
+}
+
+func (testCodec) Decode(r netpoll.Reader) (id uint64, msg interface{}, err error) {
+	head, err := r.Next(12)
+	if err != nil {
+		return 0, nil, err
+	}
+	id = binary.BigEndian.Uint64(head)
+	s, err := r.ReadString(int(binary.BigEndian.Uint32(head[8:])))
+	return id, s, err
+}
+
+// serveTestCodec responds the requests out of order, and ignores the "drop" requests.
+func serveTestCodec(conn net.Conn) {
+	var mu sync.Mutex
+	var head = make([]byte, 12)
+	for {
+		if _, err := io.ReadFull(conn, head); err != nil {
+			return
+		}
+		frame := make([]byte, 12+binary.BigEndian.Uint32(head[8:]))
+		copy(frame, head)
+		if _, err := io.ReadFull(conn, frame[12:]); err != nil {
+			return
+		}
+		if string(frame[12:]) == "drop" {
+			continue
+		}
+		go func() {
+			time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond)
+			mu.Lock()
+			conn.Write(frame)
+			mu.Unlock()
+		}()
+	}
+}
+
+func newTestConn(t *testing.T) (c *Conn, svr net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			accepted <- conn
+			serveTestCodec(conn)
+		}
+	}()
+	conn, err := netpoll.DialConnection("tcp", ln.Addr().String(), time.Second)
+	MustNil(t, err)
+	return NewConn(conn, testCodec{}), <-accepted
+}
+
+func TestConnCall(t *testing.T) {
+	c, svr := newTestConn(t)
+	defer svr.Close()
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := fmt.Sprintf("req-%d", i)
+			resp, err := c.Call(context.Background(), req)
+			MustNil(t, err)
+			Equal(t, resp, req)
+		}(i)
+	}
+	wg.Wait()
+	Equal(t, c.Pending(), 0)
+
+	// encoding failure
+	_, err := c.Call(context.Background(), 1)
+	MustTrue(t, err != nil)
+	Equal(t, c.Pending(), 0)
+}
+
+func TestConnCallTimeout(t *testing.T) {
+	c, svr := newTestConn(t)
+	defer svr.Close()
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := c.Call(ctx, "drop")
+	Equal(t, err, context.DeadlineExceeded)
+	Equal(t, c.Pending(), 0)
+
+	resp, err := c.Call(context.Background(), "ok")
+	MustNil(t, err)
+	Equal(t, resp, "ok")
+}
+
+func TestConnFailAll(t *testing.T) {
+	c, svr := newTestConn(t)
+	defer c.Close()
+
+	var errs = make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			_, err := c.Call(context.Background(), "drop")
+			errs <- err
+		}()
+	}
+	for c.Pending() < 10 {
+		time.Sleep(time.Millisecond)
+	}
+	// closed by peer
+	svr.Close()
+	for i := 0; i < 10; i++ {
+		MustTrue(t, errors.Is(<-errs, netpoll.ErrConnClosed))
+	}
+	MustTrue(t, !c.IsActive())
+	_, err := c.Call(context.Background(), "ok")
+	MustTrue(t, errors.Is(err, netpoll.ErrConnClosed))
+}
@@ -20,6 +20,7 @@ package netpoll
 import (
 	"context"
 	"net"
+	"os"
 	"time"
 )
 
@@ -28,14 +29,28 @@ func DialConnection(network, address string, timeout time.Duration) (connection
 	return defaultDialer.DialConnection(network, address, timeout)
 }
 
+// DialContext is a default implementation of Dialer.
+func DialContext(ctx context.Context, network, address string) (connection Connection, err error) {
+	return defaultDialer.DialContext(ctx, network, address)
+}
+
 // NewDialer only support TCP and unix socket now.
-func NewDialer() Dialer {
-	return &dialer{}
+// The dialer can be configured by the dial options (e.g. WithDialTimeout, WithDialLocalAddr),
+// the socket options (e.g. WithTCPKeepAlive) and the connection options (e.g. WithOnRequest),
+// which are applied to every dialed connection.
+func NewDialer(opts ...Option) Dialer {
+	d := &dialer{opts: &options{}}
+	for _, do := range opts {
+		do.f(d.opts)
+	}
+	return d
 }
 
 var defaultDialer = NewDialer()
 
-type dialer struct{}
+type dialer struct {
+	opts *options
+}
 
 // DialTimeout implements Dialer.
 func (d *dialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
@@ -50,28 +65,55 @@ func (d *dialer) DialConnection(network, address string, timeout time.Duration)
 		defer cancel()
 		ctx = subCtx
 	}
+	return d.DialContext(ctx, network, address)
+}
+
+// DialContext implements Dialer.
+func (d *dialer) DialContext(ctx context.Context, network, address string) (connection Connection, err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if d.opts.dialTimeout > 0 {
+		subCtx, cancel := context.WithTimeout(ctx, d.opts.dialTimeout)
+		defer cancel()
+		ctx = subCtx
+	}
 
 	switch network {
 	case "tcp", "tcp4", "tcp6":
+		if d.opts.proxy != nil {
+			return d.dialProxy(ctx, network, address)
+		}
 		return d.dialTCP(ctx, network, address)
 	// case "udp", "udp4", "udp6":  // TODO: unsupport now
 	case "unix", "unixgram", "unixpacket":
-		raddr := &UnixAddr{
-			UnixAddr: net.UnixAddr{Name: address, Net: network},
-		}
-		return DialUnix(network, nil, raddr)
+		return d.dialUnix(ctx, network, address)
 	default:
 		return nil, net.UnknownNetworkError(network)
 	}
 }
 
 func (d *dialer) dialTCP(ctx context.Context, network, address string) (connection *TCPConnection, err error) {
+	var laddr *TCPAddr
+	switch addr := d.opts.localAddr.(type) {
+	case nil:
+	case *TCPAddr:
+		laddr = addr
+	case *net.TCPAddr:
+		laddr = &TCPAddr{*addr}
+	default:
+		return nil, &net.OpError{Op: "dial", Net: network, Source: addr, Addr: nil, Err: &net.AddrError{Err: "mismatched local address type", Addr: addr.String()}}
+	}
 	host, port, err := net.SplitHostPort(address)
 	if err != nil {
 		return nil, err
 	}
+	var resolver = d.opts.resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
 	var portnum int
-	if portnum, err = net.DefaultResolver.LookupPort(ctx, network, port); err != nil {
+	if portnum, err = resolver.LookupPort(ctx, network, port); err != nil {
 		return nil, err
 	}
 	var ipaddrs []net.IPAddr
@@ -79,7 +121,7 @@ func (d *dialer) dialTCP(ctx context.Context, network, address string) (connecti
 	if host == "" {
 		ipaddrs = []net.IPAddr{{}}
 	} else {
-		ipaddrs, err = net.DefaultResolver.LookupIPAddr(ctx, host)
+		ipaddrs, err = resolver.LookupIPAddr(ctx, host)
 		if err != nil {
 			return nil, err
 		}
@@ -88,38 +130,259 @@ func (d *dialer) dialTCP(ctx context.Context, network, address string) (connecti
 		}
 	}
 
-	var firstErr error // The error from the first address is most relevant.
-	var tcpAddr = &TCPAddr{}
+	var addrs = make([]*TCPAddr, 0, len(ipaddrs))
 	for _, ipaddr := range ipaddrs {
-		tcpAddr.IP = ipaddr.IP
-		tcpAddr.Port = portnum
-		tcpAddr.Zone = ipaddr.Zone
-		if ipaddr.IP != nil && ipaddr.IP.To4() == nil {
-			connection, err = DialTCP(ctx, "tcp6", nil, tcpAddr)
-		} else {
-			connection, err = DialTCP(ctx, "tcp", nil, tcpAddr)
+		switch {
+		case network == "tcp4" && ipaddr.IP != nil && ipaddr.IP.To4() == nil:
+		case network == "tcp6" && ipaddr.IP.To4() != nil:
+		default:
+			var tcpAddr = &TCPAddr{}
+			tcpAddr.IP = ipaddr.IP
+			tcpAddr.Port = portnum
+			tcpAddr.Zone = ipaddr.Zone
+			addrs = append(addrs, tcpAddr)
 		}
-		if err == nil {
-			return connection, nil
+	}
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{Err: "no suitable address found", Name: host, IsNotFound: true}
+	}
+
+	var primaries, fallbacks = addrs, []*TCPAddr(nil)
+	if network == "tcp" && d.opts.fallbackDelay >= 0 {
+		primaries, fallbacks = partition(addrs)
+	}
+	return d.dialParallel(ctx, laddr, primaries, fallbacks)
+}
+
+// dialParallel races two copies of dialSerial, giving the first a
+// head start. It returns the first established connection and
+// closes the others. Otherwise it returns an error from the first
+// primary address.
+func (d *dialer) dialParallel(ctx context.Context, laddr *TCPAddr, primaries, fallbacks []*TCPAddr) (*TCPConnection, error) {
+	if len(fallbacks) == 0 {
+		return d.dialSerial(ctx, laddr, primaries)
+	}
+
+	returned := make(chan struct{})
+	defer close(returned)
+
+	type dialResult struct {
+		conn    *TCPConnection
+		err     error
+		primary bool
+		done    bool
+	}
+	results := make(chan dialResult) // unbuffered
+
+	startRacer := func(ctx context.Context, primary bool) {
+		ras := primaries
+		if !primary {
+			ras = fallbacks
 		}
+		c, err := d.dialSerial(ctx, laddr, ras)
 		select {
-		case <-ctx.Done(): // check timeout error
-			return nil, err
+		case results <- dialResult{conn: c, err: err, primary: primary, done: true}:
+		case <-returned:
+			if c != nil {
+				c.Close()
+			}
+		}
+	}
+
+	var primary, fallback dialResult
+
+	// Start the main racer.
+	primaryCtx, primaryCancel := context.WithCancel(ctx)
+	defer primaryCancel()
+	go startRacer(primaryCtx, true)
+
+	// Start the timer for the fallback racer.
+	fallbackTimer := time.NewTimer(d.fallbackDelay())
+	defer fallbackTimer.Stop()
+
+	for {
+		select {
+		case <-fallbackTimer.C:
+			fallbackCtx, fallbackCancel := context.WithCancel(ctx)
+			defer fallbackCancel()
+			go startRacer(fallbackCtx, false)
+
+		case res := <-results:
+			if res.err == nil {
+				return res.conn, nil
+			}
+			if res.primary {
+				primary = res
+			} else {
+				fallback = res
+			}
+			if primary.done && fallback.done {
+				return nil, primary.err
+			}
+			if res.primary && fallbackTimer.Stop() {
+				// If we were able to stop the timer, that means it
+				// was running (hadn't yet started the fallback), but
+				// we just got an error on the primary path, so start
+				// the fallback immediately (in 0 nanoseconds).
+				fallbackTimer.Reset(0)
+			}
+		}
+	}
+}
+
+// dialSerial connects to a list of addresses in sequence, returning
+// either the first successful connection, or the first error.
+func (d *dialer) dialSerial(ctx context.Context, laddr *TCPAddr, ras []*TCPAddr) (*TCPConnection, error) {
+	var firstErr error // The error from the first address is most relevant.
+
+	for i, ra := range ras {
+		select {
+		case <-ctx.Done():
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Source: laddr.opAddr(), Addr: ra.opAddr(), Err: mapErr(ctx.Err())}
 		default:
 		}
+
+		dialCtx := ctx
+		if deadline, hasDeadline := ctx.Deadline(); hasDeadline {
+			partialDeadline, err := partialDeadline(time.Now(), deadline, len(ras)-i)
+			if err != nil {
+				// Ran out of time.
+				if firstErr == nil {
+					firstErr = &net.OpError{Op: "dial", Net: "tcp", Source: laddr.opAddr(), Addr: ra.opAddr(), Err: err}
+				}
+				break
+			}
+			if partialDeadline.Before(deadline) {
+				var cancel context.CancelFunc
+				dialCtx, cancel = context.WithDeadline(ctx, partialDeadline)
+				defer cancel()
+			}
+		}
+
+		var sd = &sysDialer{network: "tcp", address: ra.String(), opts: d.opts}
+		if ra.IP != nil && ra.IP.To4() == nil {
+			sd.network = "tcp6"
+		}
+		c, err := sd.dialTCP(dialCtx, laddr, ra)
+		if err == nil {
+			return c, nil
+		}
 		if firstErr == nil {
 			firstErr = err
 		}
 	}
 
 	if firstErr == nil {
-		firstErr = &net.OpError{Op: "dial", Net: network, Source: nil, Addr: nil, Err: errMissingAddress}
+		firstErr = &net.OpError{Op: "dial", Net: "tcp", Source: nil, Addr: nil, Err: errMissingAddress}
 	}
 	return nil, firstErr
 }
 
+func (d *dialer) fallbackDelay() time.Duration {
+	if d.opts.fallbackDelay > 0 {
+		return d.opts.fallbackDelay
+	}
+	return defaultFallbackDelay
+}
+
+// defaultFallbackDelay is the delay of starting the fallback racer, recommended by RFC 8305.
+const defaultFallbackDelay = 300 * time.Millisecond
+
+// partialDeadline returns the deadline to use for a single address,
+// when multiple addresses are pending.
+func partialDeadline(now, deadline time.Time, addrsRemaining int) (time.Time, error) {
+	if deadline.IsZero() {
+		return deadline, nil
+	}
+	timeRemaining := deadline.Sub(now)
+	if timeRemaining <= 0 {
+		return time.Time{}, errIOTimeout
+	}
+	// Tentatively allocate equal time to each remaining address.
+	timeout := timeRemaining / time.Duration(addrsRemaining)
+	// If the time per address is too short, steal from the end of the list.
+	const saneMinimum = 2 * time.Second
+	if timeout < saneMinimum {
+		if timeRemaining < saneMinimum {
+			timeout = timeRemaining
+		} else {
+			timeout = saneMinimum
+		}
+	}
+	return now.Add(timeout), nil
+}
+
+// partition divides an address list into two categories, using the
+// address family of the first address: the primaries with the same family
+// and the fallbacks with the other.
+func partition(addrs []*TCPAddr) (primaries, fallbacks []*TCPAddr) {
+	var primaryIPv4 = addrs[0].IP == nil || addrs[0].IP.To4() != nil
+	for _, addr := range addrs {
+		if (addr.IP == nil || addr.IP.To4() != nil) == primaryIPv4 {
+			primaries = append(primaries, addr)
+		} else {
+			fallbacks = append(fallbacks, addr)
+		}
+	}
+	return primaries, fallbacks
+}
+
+func (d *dialer) dialUnix(ctx context.Context, network, address string) (connection *UnixConnection, err error) {
+	var laddr *UnixAddr
+	switch addr := d.opts.localAddr.(type) {
+	case nil:
+	case *UnixAddr:
+		laddr = addr
+	case *net.UnixAddr:
+		laddr = &UnixAddr{*addr}
+	default:
+		return nil, &net.OpError{Op: "dial", Net: network, Source: addr, Addr: nil, Err: &net.AddrError{Err: "mismatched local address type", Addr: addr.String()}}
+	}
+	raddr := &UnixAddr{
+		UnixAddr: net.UnixAddr{Name: address, Net: network},
+	}
+	sd := &sysDialer{network: network, address: address, opts: d.opts}
+	return sd.dialUnix(ctx, laddr, raddr)
+}
+
 // sysDialer contains a Dial's parameters and configuration.
 type sysDialer struct {
 	net.Dialer
 	network, address string
+	opts             *options
+}
+
+// ctrlFn returns the control hook of the socket before connecting.
+func (sd *sysDialer) ctrlFn() func(fd int) error {
+	var tcp, fastOpen bool
+	switch sd.network {
+	case "tcp", "tcp4", "tcp6":
+		tcp = true
+		fastOpen = sd.opts != nil && sd.opts.fastOpen > 0
+	}
+	var dialControl func(network, address string, fd int) error
+	if sd.opts != nil {
+		dialControl = sd.opts.dialControl
+	}
+	if !tcp && dialControl == nil {
+		return nil
+	}
+	return func(fd int) error {
+		if tcp {
+			// the socket options must be set before connecting to take effect in the handshake, such as SO_RCVBUF,
+			// and the error is already wrapped as os.SyscallError("setsockopt").
+			if err := setTCPSockopts(fd, sd.opts); err != nil {
+				return err
+			}
+		}
+		if fastOpen {
+			if err := setTCPFastOpenConnect(fd); err != nil {
+				return os.NewSyscallError("setsockopt", err)
+			}
+		}
+		if dialControl != nil {
+			return dialControl(sd.network, sd.address, fd)
+		}
+		return nil
+	}
 }
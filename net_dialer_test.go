@@ -18,12 +18,19 @@
 package netpoll
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
@@ -97,14 +104,168 @@ func TestDialerUnix(t *testing.T) {
 
 	conn, err = dialer.DialTimeout("unix", "tmp.sock", time.Second)
 	MustNil(t, err)
-	if runtime.GOOS == "linux" {
-		Equal(t, conn.LocalAddr().String(), "@")
-	} else {
-		Equal(t, conn.LocalAddr().String(), "")
-	}
+	// the unnamed address is empty, which is not mistaken for the abstract address on Linux.
+	Equal(t, conn.LocalAddr().String(), "")
 	Equal(t, conn.RemoteAddr().String(), "tmp.sock")
 }
 
+func TestDialerContext(t *testing.T) {
+	ln, err := CreateListener("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer ln.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = DialContext(ctx, "tcp", ln.Addr().String())
+	MustTrue(t, err != nil)
+
+	conn, err := DialContext(context.Background(), "tcp", ln.Addr().String())
+	MustNil(t, err)
+	MustNil(t, conn.Close())
+}
+
+func TestDialerOptions(t *testing.T) {
+	ln, err := CreateListener("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer ln.Close()
+	el, _ := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		buf, err := connection.Reader().Next(connection.Reader().Len())
+		if err != nil {
+			return err
+		}
+		connection.Writer().WriteBinary(buf)
+		return connection.Writer().Flush()
+	})
+	go func() {
+		el.Serve(ln)
+	}()
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	defer el.Shutdown(ctx)
+
+	var control, connected int32
+	var reply = make(chan string, 1)
+	dialer := NewDialer(
+		WithDialTimeout(time.Second),
+		WithDialLocalAddr(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}),
+		WithDialControl(func(network, address string, fd int) error {
+			Equal(t, network, "tcp")
+			Equal(t, address, ln.Addr().String())
+			atomic.AddInt32(&control, 1)
+			return nil
+		}),
+		WithOnConnect(func(ctx context.Context, connection Connection) context.Context {
+			atomic.AddInt32(&connected, 1)
+			return ctx
+		}),
+		WithOnRequest(func(ctx context.Context, connection Connection) error {
+			s, err := connection.Reader().ReadString(connection.Reader().Len())
+			if err == nil {
+				reply <- s
+			}
+			return err
+		}),
+	)
+	conn, err := dialer.DialContext(context.Background(), "tcp", ln.Addr().String())
+	MustNil(t, err)
+	defer conn.Close()
+	MustTrue(t, strings.HasPrefix(conn.LocalAddr().String(), "127.0.0.1:"))
+	Equal(t, atomic.LoadInt32(&control), int32(1))
+
+	_, err = conn.Write([]byte("hello"))
+	MustNil(t, err)
+	Equal(t, <-reply, "hello")
+	Equal(t, atomic.LoadInt32(&connected), int32(1))
+
+	// control hook fails the dialing
+	dialer = NewDialer(WithDialControl(func(network, address string, fd int) error {
+		return syscall.EPERM
+	}))
+	_, err = dialer.DialContext(context.Background(), "tcp", ln.Addr().String())
+	MustTrue(t, errors.Is(err, syscall.EPERM))
+
+	// mismatched local address
+	dialer = NewDialer(WithDialLocalAddr(&net.UnixAddr{Net: "unix", Name: "tmp.sock"}))
+	_, err = dialer.DialContext(context.Background(), "tcp", ln.Addr().String())
+	MustTrue(t, err != nil)
+}
+
+func TestDialerParallel(t *testing.T) {
+	ln, err := CreateListener("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer ln.Close()
+	raddr, err := ResolveTCPAddr("tcp", ln.Addr().String())
+	MustNil(t, err)
+
+	// the primary stalls and fails, the fallback connects after the fallback delay.
+	var calls int32
+	d := NewDialer(
+		WithDialFallbackDelay(50*time.Millisecond),
+		WithDialControl(func(network, address string, fd int) error {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				time.Sleep(500 * time.Millisecond)
+				return syscall.ECONNREFUSED
+			}
+			return nil
+		}),
+	).(*dialer)
+	begin := time.Now()
+	conn, err := d.dialParallel(context.Background(), nil, []*TCPAddr{raddr}, []*TCPAddr{raddr})
+	MustNil(t, err)
+	MustTrue(t, time.Since(begin) < 500*time.Millisecond)
+	conn.Close()
+
+	// both fail, returns the error of primaries
+	d = NewDialer(
+		WithDialFallbackDelay(time.Second),
+		WithDialControl(func(network, address string, fd int) error {
+			return syscall.ECONNREFUSED
+		}),
+	).(*dialer)
+	begin = time.Now()
+	_, err = d.dialParallel(context.Background(), nil, []*TCPAddr{raddr}, []*TCPAddr{raddr})
+	MustTrue(t, errors.Is(err, syscall.ECONNREFUSED))
+	// the fallback is started immediately once the primaries fail
+	MustTrue(t, time.Since(begin) < time.Second)
+}
+
+func TestDialerPartition(t *testing.T) {
+	v4a := &TCPAddr{net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}}
+	v4b := &TCPAddr{net.TCPAddr{IP: net.IPv4(127, 0, 0, 2)}}
+	v6a := &TCPAddr{net.TCPAddr{IP: net.IPv6loopback}}
+	primaries, fallbacks := partition([]*TCPAddr{v6a, v4a, v4b})
+	Equal(t, len(primaries), 1)
+	Equal(t, len(fallbacks), 2)
+	MustTrue(t, primaries[0] == v6a)
+	primaries, fallbacks = partition([]*TCPAddr{v4a, v6a, v4b})
+	Equal(t, len(primaries), 2)
+	Equal(t, len(fallbacks), 1)
+	MustTrue(t, primaries[1] == v4b)
+}
+
+func TestDialerPartialDeadline(t *testing.T) {
+	var now = time.Unix(1000, 0)
+	var cases = []struct {
+		deadline       time.Time
+		addrs          int
+		expectDeadline time.Time
+		expectErr      bool
+	}{
+		{now.Add(12 * time.Second), 1, now.Add(12 * time.Second), false},
+		{now.Add(12 * time.Second), 2, now.Add(6 * time.Second), false},
+		{now.Add(12 * time.Second), 3, now.Add(4 * time.Second), false},
+		{now.Add(3 * time.Second), 2, now.Add(2 * time.Second), false},
+		{now.Add(time.Second), 2, now.Add(time.Second), false},
+		{now, 1, time.Time{}, true},
+		{time.Time{}, 2, time.Time{}, false},
+	}
+	for _, c := range cases {
+		deadline, err := partialDeadline(now, c.deadline, c.addrs)
+		Equal(t, err != nil, c.expectErr)
+		MustTrue(t, deadline.Equal(c.expectDeadline))
+	}
+}
+
 func TestDialerFdAlloc(t *testing.T) {
 	ln, err := CreateListener("tcp", ":1234")
 	MustNil(t, err)
@@ -164,7 +325,397 @@ func TestFDClose(t *testing.T) {
 	conn.Close()
 }
 
+func TestTCPInfo(t *testing.T) {
+	ln, err := CreateListener("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer ln.Close()
+	type result struct {
+		info *TCPInfo
+		err  error
+	}
+	var accepted = make(chan result, 1)
+	el, _ := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		return nil
+	}, WithOnConnect(func(ctx context.Context, conn Connection) context.Context {
+		info, err := conn.(TCPConn).TCPInfo()
+		accepted <- result{info: info, err: err}
+		return ctx
+	}))
+	go func() {
+		el.Serve(ln)
+	}()
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	defer el.Shutdown(ctx)
+
+	conn, err := DialConnection("tcp", ln.Addr().String(), time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	info, err := conn.(TCPConn).TCPInfo()
+	res := <-accepted
+	if runtime.GOOS != "linux" {
+		MustTrue(t, err != nil)
+		MustTrue(t, res.err != nil)
+		return
+	}
+	MustNil(t, err)
+	MustTrue(t, info.SndMSS > 0)
+	MustTrue(t, info.RTO > 0)
+	// accepted by EventLoop
+	MustNil(t, res.err)
+	MustTrue(t, res.info.SndMSS > 0)
+
+	// not a TCP connection
+	var uconn = newConnection("unix").self()
+	_, ok := uconn.(TCPConn)
+	MustTrue(t, !ok)
+}
+
+func TestTCPSockopts(t *testing.T) {
+	var sockopts = []Option{
+		WithTCPNoDelay(false),
+		WithTCPKeepAlive(time.Minute, 10*time.Second, 3),
+		WithSocketReadBuffer(64 * 1024),
+	}
+	ln, err := CreateListener("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer ln.Close()
+	var accepted = make(chan TCPConn, 1)
+	el, _ := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		return nil
+	}, append(sockopts, WithOnPrepare(func(conn Connection) context.Context {
+		accepted <- conn.(TCPConn)
+		return context.Background()
+	}))...)
+	go func() {
+		el.Serve(ln)
+	}()
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	defer el.Shutdown(ctx)
+
+	var check = func(fd int) {
+		n, _ := syscall.GetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+		Equal(t, n, 0)
+		n, _ = syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE)
+		MustTrue(t, n != 0)
+		n, _ = syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+		MustTrue(t, n >= 64*1024)
+	}
+	// the socket options are set before connecting
+	var preconnect = func(network, address string, fd int) error {
+		check(fd)
+		return nil
+	}
+	conn, err := NewDialer(append(sockopts, WithDialControl(preconnect))...).DialConnection("tcp", ln.Addr().String(), time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	check(conn.(*TCPConnection).fd)
+	aconn := <-accepted
+	check(aconn.(*TCPConnection).fd)
+
+	// set by the accepted connection
+	MustNil(t, aconn.SetNoDelay(true))
+	n, _ := syscall.GetsockoptInt(aconn.(*TCPConnection).fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+	MustTrue(t, n != 0)
+	MustNil(t, aconn.SetKeepAlive(0, 0, 0))
+	n, _ = syscall.GetsockoptInt(aconn.(*TCPConnection).fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE)
+	Equal(t, n, 0)
+
+	// set by TCPConnection
+	tconn := conn.(*TCPConnection)
+	MustNil(t, tconn.SetNoDelay(true))
+	n, _ = syscall.GetsockoptInt(tconn.fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+	MustTrue(t, n != 0)
+	MustNil(t, tconn.SetLinger(0))
+	MustNil(t, tconn.SetWriteBuffer(64*1024))
+	MustNil(t, tconn.SetKeepAlive(0, 0, 0))
+	n, _ = syscall.GetsockoptInt(tconn.fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE)
+	Equal(t, n, 0)
+	if runtime.GOOS == "linux" {
+		MustNil(t, tconn.SetQuickAck(true))
+		MustNil(t, tconn.SetCork(true))
+		MustNil(t, tconn.SetCork(false))
+		MustNil(t, tconn.SetUserTimeout(time.Second))
+		MustNil(t, tconn.SetMark(0))
+	} else {
+		MustTrue(t, errors.Is(tconn.SetQuickAck(true), ErrUnsupported))
+	}
+
+	// the dialer fails if the socket options cannot be set
+	_, err = NewDialer(WithTCPCongestion("unknown")).DialConnection("tcp", ln.Addr().String(), time.Second)
+	var serr *os.SyscallError
+	MustTrue(t, errors.As(err, &serr))
+	Equal(t, serr.Syscall, "setsockopt")
+}
+
+func TestTCPFastOpen(t *testing.T) {
+	ln, err := CreateListener("tcp", "127.0.0.1:0", WithTCPFastOpen(16))
+	if runtime.GOOS != "linux" {
+		MustTrue(t, err != nil)
+		return
+	}
+	MustNil(t, err)
+	defer ln.Close()
+	n, _ := syscall.GetsockoptInt(ln.Fd(), syscall.IPPROTO_TCP, 0x17)
+	Equal(t, n, 16)
+	el, _ := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		buf, err := connection.Reader().Next(connection.Reader().Len())
+		if err != nil {
+			return err
+		}
+		connection.Writer().WriteBinary(buf)
+		return connection.Writer().Flush()
+	})
+	go func() {
+		el.Serve(ln)
+	}()
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	defer el.Shutdown(ctx)
+
+	dialer := NewDialer(WithTCPFastOpen(1))
+	for i := 0; i < 2; i++ { // the second one may carry the data in SYN with the cookie
+		conn, err := dialer.DialConnection("tcp", ln.Addr().String(), time.Second)
+		MustNil(t, err)
+		n, _ = syscall.GetsockoptInt(conn.(*TCPConnection).fd, syscall.IPPROTO_TCP, 0x1e)
+		Equal(t, n, 1)
+		_, err = conn.Write([]byte("hello"))
+		MustNil(t, err)
+		s, err := conn.Reader().ReadString(5)
+		MustNil(t, err)
+		Equal(t, s, "hello")
+		conn.Close()
+	}
+}
+
 // fd data package race test, use two servers and two dialers.
+func TestDialerProxy(t *testing.T) {
+	// echo server as the destination
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	socks5, err := net.Listen("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer socks5.Close()
+	go mockSocks5Proxy(socks5, "user", "pass", nil)
+
+	httpProxy, err := net.Listen("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer httpProxy.Close()
+	go mockHTTPConnectProxy(httpProxy, "Basic dXNlcjpwYXNz") // user:pass
+
+	var proxies = []string{
+		"socks5://user:pass@" + socks5.Addr().String(),
+		"http://user:pass@" + httpProxy.Addr().String(),
+	}
+	for _, proxy := range proxies {
+		u, err := url.Parse(proxy)
+		MustNil(t, err)
+		var received = make(chan string, 1)
+		dialer := NewDialer(WithDialProxy(u), WithOnRequest(func(ctx context.Context, connection Connection) error {
+			buf, err := connection.Reader().Next(5)
+			if err == nil {
+				received <- string(buf)
+			}
+			return err
+		}))
+		conn, err := dialer.DialConnection("tcp", target.Addr().String(), time.Second)
+		MustNil(t, err)
+		_, err = conn.Writer().WriteString("hello")
+		MustNil(t, err)
+		MustNil(t, conn.Writer().Flush())
+		select {
+		case msg := <-received:
+			Equal(t, msg, "hello")
+		case <-time.After(time.Second):
+			t.Fatalf("echo through %s timeout", u.Scheme)
+		}
+		MustNil(t, conn.Close())
+
+		// wrong password
+		u.User = url.UserPassword("user", "wrong")
+		_, err = NewDialer(WithDialProxy(u)).DialConnection("tcp", target.Addr().String(), time.Second)
+		MustTrue(t, err != nil)
+		var opErr *net.OpError
+		MustTrue(t, errors.As(err, &opErr))
+		Equal(t, opErr.Op, "proxyconnect")
+	}
+
+	// unsupported scheme
+	_, err = NewDialer(WithDialProxy(&url.URL{Scheme: "ftp", Host: socks5.Addr().String()})).DialConnection("tcp", target.Addr().String(), time.Second)
+	MustTrue(t, err != nil)
+}
+
+func TestDialerProxySocks5Resolve(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	socks5, err := net.Listen("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer socks5.Close()
+	var hosts = make(chan string, 1)
+	go mockSocks5Proxy(socks5, "user", "pass", hosts)
+
+	var resolver = &mockResolver{}
+	resolver.set([]net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil)
+	_, port, _ := net.SplitHostPort(target.Addr().String())
+	var address = net.JoinHostPort("target.test", port)
+
+	// socks5 resolves the host locally
+	u, err := url.Parse("socks5://user:pass@" + socks5.Addr().String())
+	MustNil(t, err)
+	conn, err := NewDialer(WithDialProxy(u), WithDialResolver(resolver)).DialConnection("tcp", address, time.Second)
+	MustNil(t, err)
+	conn.Close()
+	Equal(t, <-hosts, "127.0.0.1")
+
+	// socks5h sends the host name to the proxy
+	u.Scheme = "socks5h"
+	_, err = NewDialer(WithDialProxy(u), WithDialResolver(resolver)).DialConnection("tcp", address, time.Second)
+	MustTrue(t, err != nil)
+	Equal(t, <-hosts, "target.test")
+}
+
+// mockSocks5Proxy sends the requested hosts to hosts if it is not nil.
+func mockSocks5Proxy(ln net.Listener, username, password string, hosts chan<- string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			var buf = make([]byte, 256)
+			// greeting: require username/password
+			if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+				return
+			}
+			if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+				return
+			}
+			conn.Write([]byte{0x05, 0x02})
+			// username/password
+			if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+				return
+			}
+			var user = make([]byte, buf[1])
+			io.ReadFull(conn, user)
+			io.ReadFull(conn, buf[:1])
+			var pass = make([]byte, buf[0])
+			io.ReadFull(conn, pass)
+			if string(user) != username || string(pass) != password {
+				conn.Write([]byte{0x01, 0x01})
+				return
+			}
+			conn.Write([]byte{0x01, 0x00})
+			// connect
+			if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+				return
+			}
+			var host string
+			switch buf[3] {
+			case 0x01:
+				io.ReadFull(conn, buf[:net.IPv4len])
+				host = net.IP(buf[:net.IPv4len]).String()
+			case 0x04:
+				io.ReadFull(conn, buf[:net.IPv6len])
+				host = net.IP(buf[:net.IPv6len]).String()
+			case 0x03:
+				io.ReadFull(conn, buf[:1])
+				var name = make([]byte, buf[0])
+				io.ReadFull(conn, name)
+				host = string(name)
+			}
+			if hosts != nil {
+				hosts <- host
+			}
+			io.ReadFull(conn, buf[:2])
+			port := int(buf[0])<<8 | int(buf[1])
+			target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+			if err != nil {
+				conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+				return
+			}
+			defer target.Close()
+			conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+			go io.Copy(target, conn)
+			io.Copy(conn, target)
+		}()
+	}
+}
+
+func mockHTTPConnectProxy(ln net.Listener, auth string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			reader := bufio.NewReader(conn)
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			if len(fields) != 3 || fields[0] != "CONNECT" {
+				conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+				return
+			}
+			var authorized bool
+			for {
+				line, err = reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				line = strings.TrimSpace(line)
+				if line == "" {
+					break
+				}
+				if line == "Proxy-Authorization: "+auth {
+					authorized = true
+				}
+			}
+			if !authorized {
+				conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+				return
+			}
+			target, err := net.Dial("tcp", fields[1])
+			if err != nil {
+				conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+				return
+			}
+			defer target.Close()
+			conn.Write([]byte("HTTP/1.1 200 Connection established\r\nProxy-Agent: mock\r\n\r\n"))
+			go io.Copy(target, reader)
+			io.Copy(conn, target)
+		}()
+	}
+}
+
 func TestDialerThenClose(t *testing.T) {
 	// server 1
 	ln1, _ := CreateListener("tcp", ":1231")
@@ -217,7 +768,7 @@ func mockDialerEventLoop(idx int) EventLoop {
 				conn.Close()
 			}
 		}()
-		operator := conn.(*connection)
+		operator := &conn.(*TCPConnection).connection
 		fd := operator.fd
 		msg := make([]byte, 15)
 		n, err := operator.Read(msg)
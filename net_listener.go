@@ -18,6 +18,7 @@
 package netpoll
 
 import (
+	"context"
 	"errors"
 	"net"
 	"os"
@@ -25,13 +26,33 @@ import (
 )
 
 // CreateListener return a new Listener.
-func CreateListener(network, addr string) (l Listener, err error) {
+// The listener options (e.g. WithTCPFastOpen) are applied to the listening socket.
+func CreateListener(network, addr string, opts ...Option) (l Listener, err error) {
 	if network == "udp" {
 		// TODO: udp listener.
 		return udpListener(network, addr)
 	}
+	var o = &options{}
+	for _, do := range opts {
+		do.f(o)
+	}
+	var lc net.ListenConfig
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		if o.fastOpen > 0 {
+			lc.Control = func(network, address string, c syscall.RawConn) error {
+				var serr error
+				if err := c.Control(func(fd uintptr) {
+					serr = setTCPFastOpen(int(fd), o.fastOpen)
+				}); err != nil {
+					return err
+				}
+				return os.NewSyscallError("setsockopt", serr)
+			}
+		}
+	}
 	// tcp, tcp4, tcp6, unix
-	ln, err := net.Listen(network, addr)
+	ln, err := lc.Listen(context.Background(), network, addr)
 	if err != nil {
 		return nil, err
 	}
@@ -101,6 +122,11 @@ func (ln *listener) Accept() (net.Conn, error) {
 	nfd.localAddr = ln.addr
 	nfd.network = ln.addr.Network()
 	nfd.remoteAddr = sockaddrToAddr(sa)
+	if _, ok := sa.(*syscall.SockaddrUnix); ok {
+		if addr := unixSockAddr(fd, nfd.network, true); addr != nil {
+			nfd.remoteAddr = addr
+		}
+	}
 	return nfd, nil
 }
 
@@ -104,6 +104,15 @@ func (c *netFD) dial(ctx context.Context, laddr, raddr sockaddr) (err error) {
 	} else {
 		c.remoteAddr = sockaddrToAddr(rsa)
 	}
+	if c.family == syscall.AF_UNIX {
+		// syscall.Sockaddr cannot tell the unnamed address from the abstract one.
+		if addr := unixSockAddr(c.fd, c.network, false); addr != nil {
+			c.localAddr = addr
+		}
+		if addr := unixSockAddr(c.fd, c.network, true); addr != nil {
+			c.remoteAddr = addr
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,295 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// dialProxy dials the proxy and tunnels to the address by the proxy handshake.
+// The connection options (e.g. OnRequest) are applied after the handshake finished.
+func (d *dialer) dialProxy(ctx context.Context, network, address string) (connection *TCPConnection, err error) {
+	var proxy = d.opts.proxy
+	var handshake func(ctx context.Context, conn Connection, proxy *url.URL, address string) error
+	switch proxy.Scheme {
+	case "socks5":
+		// the host is resolved locally, and the proxy receives the IP address.
+		if address, err = d.resolveTarget(ctx, network, address); err != nil {
+			return nil, err
+		}
+		handshake = socks5Handshake
+	case "socks5h":
+		handshake = socks5Handshake
+	case "http":
+		handshake = httpConnectHandshake
+	default:
+		return nil, &net.OpError{Op: "proxyconnect", Net: network, Err: errors.New("unsupported proxy scheme: " + proxy.Scheme)}
+	}
+	var proxyAddr = proxy.Host
+	if proxy.Port() == "" {
+		switch proxy.Scheme {
+		case "http":
+			proxyAddr = net.JoinHostPort(proxy.Hostname(), "80")
+		default:
+			proxyAddr = net.JoinHostPort(proxy.Hostname(), "1080")
+		}
+	}
+
+	// dial the proxy without the connection options
+	var opts = d.opts.bare()
+	opts.proxy = nil
+	connection, err = (&dialer{opts: opts}).dialTCP(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the connection is closed to interrupt the handshake if ctx is done.
+	var stop = make(chan struct{})
+	var interrupted = make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			connection.Close()
+			interrupted <- true
+		case <-stop:
+			interrupted <- false
+		}
+	}()
+	err = handshake(ctx, connection, proxy, address)
+	close(stop)
+	if <-interrupted {
+		err = mapErr(ctx.Err())
+	}
+	if err != nil {
+		connection.Close()
+		return nil, &net.OpError{Op: "proxyconnect", Net: network, Source: connection.LocalAddr(), Addr: connection.RemoteAddr(), Err: err}
+	}
+	connection.prepareRegistered(d.opts)
+	return connection, nil
+}
+
+// resolveTarget resolves the host of address by the resolver of the dialer, and returns the first IP address
+// suitable for network with the port number.
+func (d *dialer) resolveTarget(ctx context.Context, network, address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	var resolver = d.opts.resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	portnum, err := resolver.LookupPort(ctx, network, port)
+	if err != nil {
+		return "", err
+	}
+	ipaddrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	for _, ipaddr := range ipaddrs {
+		switch {
+		case network == "tcp4" && ipaddr.IP.To4() == nil:
+		case network == "tcp6" && ipaddr.IP.To4() != nil:
+		default:
+			return net.JoinHostPort(ipaddr.IP.String(), strconv.Itoa(portnum)), nil
+		}
+	}
+	return "", &net.DNSError{Err: "no suitable address found", Name: host, IsNotFound: true}
+}
+
+// socks5Handshake implements the CONNECT command of SOCKS5 (RFC 1928)
+// and the username/password authentication (RFC 1929).
+func socks5Handshake(ctx context.Context, conn Connection, proxy *url.URL, address string) (err error) {
+	host, port, err := splitHostPort(ctx, address)
+	if err != nil {
+		return err
+	}
+	var reader, writer = conn.Reader(), conn.Writer()
+	defer reader.Release()
+
+	// greeting
+	var methods = []byte{0x00} // no authentication
+	if proxy.User != nil {
+		methods = append(methods, 0x02) // username/password
+	}
+	writer.WriteBinary([]byte{0x05, byte(len(methods))})
+	writer.WriteBinary(methods)
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	buf, err := reader.Next(2)
+	if err != nil {
+		return err
+	}
+	if buf[0] != 0x05 {
+		return errors.New("socks5: unexpected protocol version " + strconv.Itoa(int(buf[0])))
+	}
+	switch buf[1] {
+	case 0x00:
+	case 0x02:
+		if proxy.User == nil {
+			return errors.New("socks5: unexpected authentication method")
+		}
+		username := proxy.User.Username()
+		password, _ := proxy.User.Password()
+		if len(username) == 0 || len(username) > 255 || len(password) > 255 {
+			return errors.New("socks5: invalid username/password")
+		}
+		writer.WriteBinary([]byte{0x01, byte(len(username))})
+		writer.WriteString(username)
+		writer.WriteByte(byte(len(password)))
+		writer.WriteString(password)
+		if err = writer.Flush(); err != nil {
+			return err
+		}
+		if buf, err = reader.Next(2); err != nil {
+			return err
+		}
+		if buf[1] != 0x00 {
+			return errors.New("socks5: username/password authentication failed")
+		}
+	default:
+		return errors.New("socks5: no acceptable authentication methods")
+	}
+
+	// connect
+	writer.WriteBinary([]byte{0x05, 0x01, 0x00})
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return errors.New("socks5: host name too long")
+		}
+		writer.WriteBinary([]byte{0x03, byte(len(host))})
+		writer.WriteString(host)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		writer.WriteByte(0x01)
+		writer.WriteBinary(ip4)
+	} else {
+		writer.WriteByte(0x04)
+		writer.WriteBinary(ip.To16())
+	}
+	var p = make([]byte, 2)
+	binary.BigEndian.PutUint16(p, uint16(port))
+	writer.WriteBinary(p)
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	if buf, err = reader.Next(4); err != nil {
+		return err
+	}
+	if buf[1] != 0x00 {
+		return errors.New("socks5: connect failed: " + socks5Reply(buf[1]))
+	}
+	// skip the bound address
+	var l int
+	switch buf[3] {
+	case 0x01:
+		l = net.IPv4len
+	case 0x04:
+		l = net.IPv6len
+	case 0x03:
+		if buf, err = reader.Next(1); err != nil {
+			return err
+		}
+		l = int(buf[0])
+	default:
+		return errors.New("socks5: unknown address type " + strconv.Itoa(int(buf[3])))
+	}
+	return reader.Skip(l + 2)
+}
+
+func socks5Reply(code byte) string {
+	switch code {
+	case 0x01:
+		return "general failure"
+	case 0x02:
+		return "connection not allowed by ruleset"
+	case 0x03:
+		return "network unreachable"
+	case 0x04:
+		return "host unreachable"
+	case 0x05:
+		return "connection refused"
+	case 0x06:
+		return "TTL expired"
+	case 0x07:
+		return "command not supported"
+	case 0x08:
+		return "address type not supported"
+	}
+	return "unknown code " + strconv.Itoa(int(code))
+}
+
+// httpConnectHandshake tunnels by the CONNECT method of HTTP/1.1.
+func httpConnectHandshake(ctx context.Context, conn Connection, proxy *url.URL, address string) (err error) {
+	var reader, writer = conn.Reader(), conn.Writer()
+	defer reader.Release()
+
+	writer.WriteString("CONNECT " + address + " HTTP/1.1\r\nHost: " + address + "\r\n")
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		writer.WriteString("Proxy-Authorization: Basic " + auth + "\r\n")
+	}
+	writer.WriteString("\r\n")
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+
+	// status line, e.g. HTTP/1.1 200 Connection established
+	line, err := reader.Until('\n')
+	if err != nil {
+		return err
+	}
+	status := strings.TrimSpace(string(line))
+	fields := strings.SplitN(status, " ", 3)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "HTTP/1.") {
+		return errors.New("http proxy: malformed response: " + status)
+	}
+	if fields[1] != "200" {
+		return errors.New("http proxy: " + status)
+	}
+	// skip the headers until an empty line
+	for {
+		if line, err = reader.Until('\n'); err != nil {
+			return err
+		}
+		if len(strings.TrimSpace(string(line))) == 0 {
+			return nil
+		}
+	}
+}
+
+// splitHostPort splits the address and looks up the port by net.DefaultResolver if it is not a number.
+func splitHostPort(ctx context.Context, address string) (host string, port int, err error) {
+	host, service, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, err
+	}
+	if port, err = strconv.Atoi(service); err != nil {
+		port, err = net.DefaultResolver.LookupPort(ctx, "tcp", service)
+	}
+	return host, port, err
+}
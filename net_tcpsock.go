@@ -15,6 +15,7 @@ import (
 	"net"
 	"os"
 	"syscall"
+	"time"
 )
 
 // TCPAddr represents the address of a TCP end point.
@@ -137,13 +138,132 @@ type TCPConnection struct {
 	connection
 }
 
+// TCPInfo is a subset of the TCP_INFO of a TCP connection maintained by the kernel.
+type TCPInfo struct {
+	RTT          time.Duration // smoothed round trip time
+	RTTVar       time.Duration // round trip time variance
+	RTO          time.Duration // retransmission timeout
+	Retransmits  uint32        // the number of retransmits of the unacknowledged data
+	TotalRetrans uint32        // the total number of retransmits during the lifetime
+	Lost         uint32        // the number of packets considered lost
+	Unacked      uint32        // the number of unacknowledged packets
+	SndMSS       uint32        // the maximum segment size for sending
+	SndCwnd      uint32        // the congestion window in packets
+}
+
+// TCPConn is implemented by the TCP connections of netpoll, including *TCPConnection returned by dialers
+// and the TCP connections accepted by EventLoop, which can be asserted from Connection as conn.(TCPConn).
+type TCPConn interface {
+	Connection
+
+	// TCPInfo returns the TCP_INFO of the connection, which is only supported on linux.
+	TCPInfo() (*TCPInfo, error)
+
+	// SetNoDelay controls whether the operating system should delay packet transmission
+	// in hopes of sending fewer packets (Nagle's algorithm). The default is true (no delay).
+	SetNoDelay(noDelay bool) error
+
+	// SetLinger sets the behavior of Close on a connection which still has data waiting to be sent.
+	// Same as net.TCPConn, sec < 0 sends the data in the background, sec == 0 discards the unsent data,
+	// and sec > 0 sends the data in the background and discards the remaining data after sec seconds.
+	SetLinger(sec int) error
+
+	// SetKeepAlive enables TCP keep-alive, which starts probing after the connection has been idle for idle,
+	// then sends at most count probes every interval. It disables keep-alive if idle <= 0,
+	// and interval or count <= 0 means using the system default.
+	SetKeepAlive(idle, interval time.Duration, count int) error
+
+	// SetQuickAck enables or disables TCP_QUICKACK, which is only supported on linux.
+	// Note that the kernel may reset the quick ack mode, so it is usually set after each read.
+	SetQuickAck(quickAck bool) error
+
+	// SetCork enables or disables TCP_CORK (TCP_NOPUSH on BSD), which holds the partial frames
+	// until uncorked. Note that TCP_NODELAY should be disabled when using cork on BSD.
+	SetCork(cork bool) error
+
+	// SetUserTimeout sets TCP_USER_TIMEOUT, the maximum time that transmitted data may remain
+	// unacknowledged before the connection is closed by the kernel. It is only supported on linux.
+	SetUserTimeout(timeout time.Duration) error
+
+	// SetCongestion sets the congestion control algorithm, e.g. "cubic" or "bbr". It is only supported on linux.
+	SetCongestion(name string) error
+
+	// SetReadBuffer sets the size of the operating system's receive buffer associated with the connection.
+	SetReadBuffer(bytes int) error
+
+	// SetWriteBuffer sets the size of the operating system's transmit buffer associated with the connection.
+	SetWriteBuffer(bytes int) error
+
+	// SetMark sets SO_MARK for the packets sent by the connection, which is only supported on linux.
+	SetMark(mark int) error
+}
+
+var _ TCPConn = &TCPConnection{}
+
+// TCPInfo implements TCPConn.
+func (c *TCPConnection) TCPInfo() (*TCPInfo, error) {
+	return getTCPInfo(c.fd)
+}
+
+// SetNoDelay implements TCPConn.
+func (c *TCPConnection) SetNoDelay(noDelay bool) error {
+	return os.NewSyscallError("setsockopt", setTCPNoDelay(c.fd, noDelay))
+}
+
+// SetLinger implements TCPConn.
+func (c *TCPConnection) SetLinger(sec int) error {
+	return os.NewSyscallError("setsockopt", setLinger(c.fd, sec))
+}
+
+// SetKeepAlive implements TCPConn.
+func (c *TCPConnection) SetKeepAlive(idle, interval time.Duration, count int) error {
+	return os.NewSyscallError("setsockopt", setKeepAlive(c.fd, idle, interval, count))
+}
+
+// SetQuickAck implements TCPConn.
+func (c *TCPConnection) SetQuickAck(quickAck bool) error {
+	return os.NewSyscallError("setsockopt", setTCPQuickAck(c.fd, quickAck))
+}
+
+// SetCork implements TCPConn.
+func (c *TCPConnection) SetCork(cork bool) error {
+	return os.NewSyscallError("setsockopt", setTCPCork(c.fd, cork))
+}
+
+// SetUserTimeout implements TCPConn.
+func (c *TCPConnection) SetUserTimeout(timeout time.Duration) error {
+	return os.NewSyscallError("setsockopt", setTCPUserTimeout(c.fd, timeout))
+}
+
+// SetCongestion implements TCPConn.
+func (c *TCPConnection) SetCongestion(name string) error {
+	return os.NewSyscallError("setsockopt", setTCPCongestion(c.fd, name))
+}
+
+// SetReadBuffer implements TCPConn.
+func (c *TCPConnection) SetReadBuffer(bytes int) error {
+	return os.NewSyscallError("setsockopt", setReadBuffer(c.fd, bytes))
+}
+
+// SetWriteBuffer implements TCPConn.
+func (c *TCPConnection) SetWriteBuffer(bytes int) error {
+	return os.NewSyscallError("setsockopt", setWriteBuffer(c.fd, bytes))
+}
+
+// SetMark implements TCPConn.
+func (c *TCPConnection) SetMark(mark int) error {
+	return os.NewSyscallError("setsockopt", setMark(c.fd, mark))
+}
+
 // newTCPConnection wraps *TCPConnection.
-func newTCPConnection(conn Conn) (connection *TCPConnection, err error) {
+func newTCPConnection(conn Conn, opts *options) (connection *TCPConnection, err error) {
 	connection = &TCPConnection{}
-	err = connection.init(conn, nil)
+	connection.outer = connection
+	err = connection.init(conn, opts)
 	if err != nil {
 		return nil, err
 	}
+	connection.onConnect()
 	return connection, nil
 }
 
@@ -167,15 +287,19 @@ func DialTCP(ctx context.Context, network string, laddr, raddr *TCPAddr) (*TCPCo
 		ctx = context.Background()
 	}
 	sd := &sysDialer{network: network, address: raddr.String()}
-	c, err := sd.dialTCP(ctx, laddr, raddr)
+	return sd.dialTCP(ctx, laddr, raddr)
+}
+
+func (sd *sysDialer) dialTCP(ctx context.Context, laddr, raddr *TCPAddr) (*TCPConnection, error) {
+	c, err := sd.doDialTCP(ctx, laddr, raddr)
 	if err != nil {
-		return nil, &net.OpError{Op: "dial", Net: network, Source: laddr.opAddr(), Addr: raddr.opAddr(), Err: err}
+		return nil, &net.OpError{Op: "dial", Net: sd.network, Source: laddr.opAddr(), Addr: raddr.opAddr(), Err: err}
 	}
 	return c, nil
 }
 
-func (sd *sysDialer) dialTCP(ctx context.Context, laddr, raddr *TCPAddr) (*TCPConnection, error) {
-	conn, err := internetSocket(ctx, sd.network, laddr, raddr, syscall.SOCK_STREAM, 0, "dial")
+func (sd *sysDialer) doDialTCP(ctx context.Context, laddr, raddr *TCPAddr) (*TCPConnection, error) {
+	conn, err := internetSocket(ctx, sd.network, laddr, raddr, syscall.SOCK_STREAM, 0, "dial", sd.ctrlFn())
 
 	// TCP has a rarely used mechanism called a 'simultaneous connection' in
 	// which Dial("tcp", addr1, addr2) run on the machine at addr1 can
@@ -205,13 +329,13 @@ func (sd *sysDialer) dialTCP(ctx context.Context, laddr, raddr *TCPAddr) (*TCPCo
 		if err == nil {
 			conn.Close()
 		}
-		conn, err = internetSocket(ctx, sd.network, laddr, raddr, syscall.SOCK_STREAM, 0, "dial")
+		conn, err = internetSocket(ctx, sd.network, laddr, raddr, syscall.SOCK_STREAM, 0, "dial", sd.ctrlFn())
 	}
 
 	if err != nil {
 		return nil, err
 	}
-	return newTCPConnection(conn)
+	return newTCPConnection(conn, sd.opts.dialed())
 }
 
 func selfConnect(conn *netFD, err error) bool {
@@ -68,18 +68,80 @@ func ResolveUnixAddr(network, address string) (*UnixAddr, error) {
 	return &UnixAddr{*addr}, nil
 }
 
+// unixSockAddr returns the local or peer address of unix socket, or nil if failed.
+func unixSockAddr(fd int, network string, peer bool) net.Addr {
+	name, err := getUnixName(fd, peer)
+	if err != nil {
+		return nil
+	}
+	return &net.UnixAddr{Net: network, Name: name}
+}
+
+// UnixConn is implemented by the connections of unix networks, including *UnixConnection returned by dialers
+// and the unix connections accepted by EventLoop, which can be asserted from Connection as conn.(UnixConn).
+type UnixConn interface {
+	Connection
+
+	// WriteFds sends the file descriptors (SCM_RIGHTS) with b, after the buffered data has been flushed.
+	// b must not be empty, and fds can be closed after returning.
+	WriteFds(b []byte, fds ...int) error
+
+	// ReadFds returns and removes all the file descriptors received so far, in the order of receiving.
+	// It is non-blocking, and the descriptors are received by the poller along with the data, so they may
+	// belong to the data which is buffered but not read yet. It should be called after reading the data sent
+	// by WriteFds of the peer, e.g. the descriptors sent with a message are available once ReadMessage returns it.
+	// The caller owns the returned descriptors and must close them, and the ones which are not returned
+	// are closed when the connection is closed, including the ones received after closing.
+	ReadFds() []int
+
+	// WriteCredentials sends the credentials (SCM_CREDENTIALS) with b, which is Linux-specific.
+	// The peer must SetPassCred(true) to receive the credentials.
+	WriteCredentials(b []byte, cred *UnixCredentials) error
+
+	// ReadCredentials returns and removes the latest credentials received with the data which has been read.
+	ReadCredentials() *UnixCredentials
+
+	// SetPassCred sets SO_PASSCRED, which is required to receive the credentials. It is Linux-specific.
+	SetPassCred(on bool) error
+
+	// ReadMessage reads the next message of unixgram or unixpacket connections, blocking until it is received.
+	// The message is valid until Release, and it must not be mixed with reading by Reader, which breaks the message boundary.
+	// The empty messages are returned in order, but they do not trigger OnRequest. An empty unixpacket message received
+	// right before the peer closes is indistinguishable from EOF, so it may be lost.
+	// A connection receiving a truncated message or truncated ancillary data is closed with CloseReasonReadError.
+	ReadMessage() (p []byte, err error)
+
+	// WriteMessage sends b as one message of unixgram or unixpacket connections,
+	// after the buffered data has been flushed as one message. b can be empty.
+	WriteMessage(b []byte) error
+
+	// PeerCredentials returns the credentials of the peer process (SO_PEERCRED) when connecting, which is Linux-specific.
+	PeerCredentials() (*UnixCredentials, error)
+}
+
+// UnixCredentials is the credentials of the process.
+type UnixCredentials struct {
+	Pid int32
+	Uid uint32
+	Gid uint32
+}
+
+var _ UnixConn = &UnixConnection{}
+
 // UnixConnection implements Connection.
 type UnixConnection struct {
 	connection
 }
 
 // newUnixConnection wraps UnixConnection.
-func newUnixConnection(conn Conn) (connection *UnixConnection, err error) {
+func newUnixConnection(conn Conn, opts *options) (connection *UnixConnection, err error) {
 	connection = &UnixConnection{}
-	err = connection.init(conn, nil)
+	connection.outer = connection
+	err = connection.init(conn, opts)
 	if err != nil {
 		return nil, err
 	}
+	connection.onConnect()
 	return connection, nil
 }
 
@@ -96,22 +158,18 @@ func DialUnix(network string, laddr, raddr *UnixAddr) (*UnixConnection, error) {
 		return nil, &net.OpError{Op: "dial", Net: network, Source: laddr.opAddr(), Addr: raddr.opAddr(), Err: net.UnknownNetworkError(network)}
 	}
 	sd := &sysDialer{network: network, address: raddr.String()}
-	c, err := sd.dialUnix(context.Background(), laddr, raddr)
-	if err != nil {
-		return nil, &net.OpError{Op: "dial", Net: network, Source: laddr.opAddr(), Addr: raddr.opAddr(), Err: err}
-	}
-	return c, nil
+	return sd.dialUnix(context.Background(), laddr, raddr)
 }
 
 func (sd *sysDialer) dialUnix(ctx context.Context, laddr, raddr *UnixAddr) (*UnixConnection, error) {
-	conn, err := unixSocket(ctx, sd.network, laddr, raddr, "dial")
+	conn, err := unixSocket(ctx, sd.network, laddr, raddr, "dial", sd.ctrlFn())
 	if err != nil {
-		return nil, err
+		return nil, &net.OpError{Op: "dial", Net: sd.network, Source: laddr.opAddr(), Addr: raddr.opAddr(), Err: err}
 	}
-	return newUnixConnection(conn)
+	return newUnixConnection(conn, sd.opts)
 }
 
-func unixSocket(ctx context.Context, network string, laddr, raddr sockaddr, mode string) (conn *netFD, err error) {
+func unixSocket(ctx context.Context, network string, laddr, raddr sockaddr, mode string, ctrlFn func(fd int) error) (conn *netFD, err error) {
 	var sotype int
 	switch network {
 	case "unix":
@@ -140,5 +198,5 @@ func unixSocket(ctx context.Context, network string, laddr, raddr sockaddr, mode
 		return nil, errors.New("unknown mode: " + mode)
 	}
 
-	return socket(ctx, network, syscall.AF_UNIX, sotype, 0, false, laddr, raddr)
+	return socket(ctx, network, syscall.AF_UNIX, sotype, 0, false, laddr, raddr, ctrlFn)
 }
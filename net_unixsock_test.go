@@ -0,0 +1,339 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestUnixRights(t *testing.T) {
+	var address = filepath.Join(t.TempDir(), "rights.sock")
+	ln, err := CreateListener("unix", address)
+	MustNil(t, err)
+	defer ln.Close()
+	// the server reads the content of the received file and sends it back.
+	el, _ := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		reader := connection.Reader()
+		buf, err := reader.Next(2)
+		if err != nil {
+			return err
+		}
+		Equal(t, string(buf), "ab")
+		reader.Release()
+		fds := connection.(UnixConn).ReadFds()
+		Equal(t, len(fds), 1)
+		file := os.NewFile(uintptr(fds[0]), "received")
+		defer file.Close()
+		buf = make([]byte, 5)
+		n, err := file.Read(buf)
+		MustNil(t, err)
+		connection.Writer().WriteBinary(buf[:n])
+		return connection.Writer().Flush()
+	})
+	go func() {
+		el.Serve(ln)
+	}()
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	defer el.Shutdown(ctx)
+
+	conn, err := DialConnection("unix", address, time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	uc, ok := conn.(UnixConn)
+	MustTrue(t, ok)
+
+	r, w, err := os.Pipe()
+	MustNil(t, err)
+	defer r.Close()
+	_, err = w.Write([]byte("hello"))
+	MustNil(t, err)
+	w.Close()
+	// the buffered data is sent before the file descriptors.
+	_, err = uc.Writer().WriteString("a")
+	MustNil(t, err)
+	MustNil(t, uc.WriteFds([]byte("b"), int(r.Fd())))
+	buf, err := uc.Reader().Next(5)
+	MustNil(t, err)
+	Equal(t, string(buf), "hello")
+	MustTrue(t, uc.WriteFds(nil, int(r.Fd())) != nil)
+
+	// non-unix connections
+	tcpln, err := CreateListener("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer tcpln.Close()
+	tcpconn, err := DialConnection("tcp", tcpln.Addr().String(), time.Second)
+	MustNil(t, err)
+	defer tcpconn.Close()
+	_, ok = tcpconn.(UnixConn)
+	MustTrue(t, !ok)
+}
+
+func TestUnixRightsClosed(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	MustNil(t, err)
+	defer syscall.Close(fds[1])
+	var conn = &connection{}
+	conn.init(&netFD{fd: fds[0], network: "unix"}, nil)
+	var controlAck = conn.operator.controlAck
+
+	var closed = func(fd int) bool {
+		_, _, e := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFD, 0)
+		return e == syscall.EBADF
+	}
+	var received = func() int {
+		fd, err := syscall.Dup(fds[1])
+		MustNil(t, err)
+		controlAck(syscall.UnixRights(fd))
+		return fd
+	}
+	// the unread descriptors are closed when closing
+	fd := received()
+	MustNil(t, conn.Close())
+	MustTrue(t, closed(fd))
+	// and the ones received after closing
+	fd = received()
+	MustTrue(t, closed(fd))
+}
+
+func TestUnixCredentials(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("credentials are Linux-specific")
+	}
+	var address = filepath.Join(t.TempDir(), "cred.sock")
+	ln, err := CreateListener("unix", address)
+	MustNil(t, err)
+	defer ln.Close()
+	var creds = make(chan *UnixCredentials, 2)
+	el, _ := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		reader := connection.Reader()
+		if _, err := reader.Next(reader.Len()); err != nil {
+			return err
+		}
+		creds <- connection.(UnixConn).ReadCredentials()
+		return nil
+	}, WithOnPrepare(func(connection Connection) context.Context {
+		MustNil(t, connection.(UnixConn).SetPassCred(true))
+		peer, err := connection.(UnixConn).PeerCredentials()
+		MustNil(t, err)
+		creds <- peer
+		return context.Background()
+	}))
+	go func() {
+		el.Serve(ln)
+	}()
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	defer el.Shutdown(ctx)
+
+	conn, err := DialConnection("unix", address, time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	peer, err := conn.(UnixConn).PeerCredentials()
+	MustNil(t, err)
+	Equal(t, int(peer.Pid), os.Getpid())
+	Equal(t, int(peer.Uid), os.Getuid())
+
+	var self = &UnixCredentials{Pid: int32(os.Getpid()), Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+	Equal(t, *(<-creds), *self) // PeerCredentials of the server
+	MustNil(t, conn.(UnixConn).WriteCredentials([]byte("x"), self))
+	select {
+	case cred := <-creds:
+		MustTrue(t, cred != nil)
+		Equal(t, *cred, *self)
+	case <-time.After(time.Second):
+		t.Fatal("OnRequest is not triggered")
+	}
+}
+
+func TestUnixAbstractAddr(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract addresses are Linux-specific")
+	}
+	var address = "@netpoll-test-" + strconv.Itoa(os.Getpid())
+	ln, err := CreateListener("unix", address)
+	MustNil(t, err)
+	defer ln.Close()
+	var remotes = make(chan string, 1)
+	el, _ := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		return nil
+	}, WithOnConnect(func(ctx context.Context, connection Connection) context.Context {
+		remotes <- connection.RemoteAddr().String()
+		return ctx
+	}))
+	go func() {
+		el.Serve(ln)
+	}()
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	defer el.Shutdown(ctx)
+
+	// no file is created for the abstract address
+	_, err = os.Stat(address)
+	MustTrue(t, os.IsNotExist(err))
+
+	conn, err := DialConnection("unix", address, time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	Equal(t, conn.RemoteAddr().String(), address)
+	Equal(t, conn.LocalAddr().String(), "")
+	Equal(t, <-remotes, "")
+
+	// bind the abstract local address
+	laddr, err := ResolveUnixAddr("unix", address+"-client")
+	MustNil(t, err)
+	raddr, err := ResolveUnixAddr("unix", address)
+	MustNil(t, err)
+	uconn, err := DialUnix("unix", laddr, raddr)
+	MustNil(t, err)
+	defer uconn.Close()
+	Equal(t, uconn.LocalAddr().String(), address+"-client")
+	Equal(t, <-remotes, address+"-client")
+}
+
+func TestUnixPacketMessage(t *testing.T) {
+	var address = filepath.Join(t.TempDir(), "packet.sock")
+	ln, err := CreateListener("unixpacket", address)
+	MustNil(t, err)
+	defer ln.Close()
+	// the server echoes every message.
+	el, _ := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		uc := connection.(UnixConn)
+		for uc.Reader().Len() > 0 {
+			msg, err := uc.ReadMessage()
+			if err != nil {
+				return err
+			}
+			if err = uc.WriteMessage(msg); err != nil {
+				return err
+			}
+			uc.Reader().Release()
+		}
+		return nil
+	})
+	go func() {
+		el.Serve(ln)
+	}()
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	defer el.Shutdown(ctx)
+
+	conn, err := DialConnection("unixpacket", address, time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	uc := conn.(UnixConn)
+	var sizes = []int{1, 100, 70000, 3}
+	for i, size := range sizes {
+		MustNil(t, uc.WriteMessage(make([]byte, size)))
+		if i == 0 {
+			// the messages are not merged by the buffered writer
+			_, err = uc.Writer().WriteBinary(make([]byte, 10))
+			MustNil(t, err)
+			MustNil(t, uc.Writer().Flush())
+		}
+	}
+	sizes = []int{1, 10, 100, 70000, 3}
+	for _, size := range sizes {
+		msg, err := uc.ReadMessage()
+		MustNil(t, err)
+		Equal(t, len(msg), size)
+	}
+	MustNil(t, uc.Reader().Release())
+
+	// stream connections
+	_, err = unixStreamConn(t).(UnixConn).ReadMessage()
+	MustTrue(t, err != nil)
+}
+
+func TestUnixgramMessage(t *testing.T) {
+	var dir = t.TempDir()
+	var address = filepath.Join(dir, "server.sock")
+	server, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: address, Net: "unixgram"})
+	MustNil(t, err)
+	defer server.Close()
+	go func() {
+		var buf = make([]byte, 1024)
+		for {
+			n, addr, err := server.ReadFromUnix(buf)
+			if err != nil {
+				return
+			}
+			server.WriteToUnix(buf[:n], addr)
+		}
+	}()
+
+	laddr := &net.UnixAddr{Name: filepath.Join(dir, "client.sock"), Net: "unixgram"}
+	conn, err := NewDialer(WithDialLocalAddr(laddr)).DialConnection("unixgram", address, time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	uc := conn.(UnixConn)
+	// the empty message is not lost
+	var msgs = []string{"hello", "", "world", "!"}
+	for _, msg := range msgs {
+		MustNil(t, uc.WriteMessage([]byte(msg)))
+	}
+	for _, expect := range msgs {
+		msg, err := uc.ReadMessage()
+		MustNil(t, err)
+		Equal(t, string(msg), expect)
+	}
+}
+
+func TestUnixPacketEmptyMessage(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET, 0)
+	MustNil(t, err)
+	var conn = &UnixConnection{}
+	MustNil(t, conn.init(&netFD{fd: fds[0], network: "unixpacket"}, nil))
+	defer conn.Close()
+	MustNil(t, conn.SetReadTimeout(time.Second))
+
+	for _, msg := range []string{"", "x", ""} {
+		MustNil(t, syscall.Sendmsg(fds[1], []byte(msg), nil, nil, 0))
+	}
+	for _, expect := range []string{"", "x", ""} {
+		msg, err := conn.ReadMessage()
+		MustNil(t, err)
+		Equal(t, string(msg), expect)
+	}
+	MustNil(t, conn.Release())
+
+	// EOF is not an empty message
+	MustNil(t, syscall.Close(fds[1]))
+	_, err = conn.ReadMessage()
+	MustTrue(t, err != nil)
+}
+
+func unixStreamConn(t *testing.T) Connection {
+	ln, err := CreateListener("unix", filepath.Join(t.TempDir(), "stream.sock"))
+	MustNil(t, err)
+	go func() {
+		ln.Accept()
+		ln.Close()
+	}()
+	conn, err := DialConnection("unix", ln.Addr().String(), time.Second)
+	MustNil(t, err)
+	return conn
+}
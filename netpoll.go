@@ -26,12 +26,12 @@ import (
 
 // NewEventLoop .
 func NewEventLoop(onRequest OnRequest, ops ...Option) (EventLoop, error) {
-	opts := &options{
-		onRequest: onRequest,
-	}
+	opts := &options{}
 	for _, do := range ops {
 		do.f(opts)
 	}
+	// WithOnRequest only applies to Dialer, the onRequest passed here is used instead.
+	opts.onRequest = onRequest
 	return &eventLoop{
 		opts: opts,
 		stop: make(chan error, 1),
@@ -70,8 +70,10 @@ func (s *server) Close(ctx context.Context) error {
 		hasConn = false
 		s.connections.Range(func(key, value interface{}) bool {
 			var conn, ok = value.(gracefulExit)
-			if !ok || conn.isIdle() {
+			if !ok {
 				value.(Connection).Close()
+			} else if conn.isIdle() {
+				conn.shutdown()
 			}
 			hasConn = true
 			return true
@@ -106,9 +108,22 @@ func (s *server) OnRead(p Poll) error {
 	if conn == nil {
 		return nil
 	}
+	if memPolicy() == MemoryPolicyRejectConn && memExceeded() {
+		conn.Close()
+		return nil
+	}
 	// store & register connection
-	var connection = &connection{}
-	connection.init(conn.(Conn), s.opts)
+	var connection = newConnection(s.ln.Addr().Network())
+	var proxyProtocol = s.opts.proxyProtocol
+	if proxyProtocol != nil && !proxyProtocol.trusted(conn.RemoteAddr()) {
+		proxyProtocol = nil
+	}
+	if proxyProtocol != nil {
+		// the options are applied after reading the header
+		connection.init(conn.(Conn), s.opts.bare())
+	} else {
+		connection.init(conn.(Conn), s.opts)
+	}
 	if !connection.IsActive() {
 		return nil
 	}
@@ -119,6 +134,12 @@ func (s *server) OnRead(p Poll) error {
 	})
 	s.connections.Store(fd, connection)
 
+	if proxyProtocol != nil {
+		runTask(connection.ctx, func() {
+			connection.onProxyConnect(proxyProtocol, s.opts)
+		})
+		return nil
+	}
 	// trigger onConnect asynchronously
 	connection.onConnect()
 	return nil
@@ -133,6 +133,24 @@ func TestOnConnectWrite(t *testing.T) {
 	MustNil(t, err)
 }
 
+func TestEventLoopWithOnRequest(t *testing.T) {
+	var called string
+	var loop, err = NewEventLoop(
+		func(ctx context.Context, connection Connection) error {
+			called = "eventloop"
+			return nil
+		},
+		// WithOnRequest is ignored by EventLoop.
+		WithOnRequest(func(ctx context.Context, connection Connection) error {
+			called = "option"
+			return nil
+		}),
+	)
+	MustNil(t, err)
+	loop.(*eventLoop).opts.onRequest(context.Background(), nil)
+	Equal(t, called, "eventloop")
+}
+
 func TestGracefulExit(t *testing.T) {
 	var network, address = "tcp", ":8888"
 
@@ -20,6 +20,7 @@ package netpoll
 
 import (
 	"net"
+	"net/url"
 	"time"
 )
 
@@ -50,8 +51,113 @@ func WithIdleTimeout(timeout time.Duration) Option {
 	return Option{}
 }
 
+// WithHalfClose makes the connections to be half-closed when the peer shuts down the writing side.
+func WithHalfClose() Option {
+	return Option{}
+}
+
+// WithTCPNoDelay sets TCP_NODELAY of TCP connections, which is enabled by default.
+func WithTCPNoDelay(noDelay bool) Option {
+	return Option{}
+}
+
+// WithTCPLinger sets SO_LINGER of TCP connections.
+func WithTCPLinger(sec int) Option {
+	return Option{}
+}
+
+// WithTCPKeepAlive sets the keep-alive of TCP connections.
+func WithTCPKeepAlive(idle, interval time.Duration, count int) Option {
+	return Option{}
+}
+
+// WithTCPQuickAck sets TCP_QUICKACK of TCP connections.
+func WithTCPQuickAck(quickAck bool) Option {
+	return Option{}
+}
+
+// WithTCPCork sets TCP_CORK of TCP connections.
+func WithTCPCork(cork bool) Option {
+	return Option{}
+}
+
+// WithTCPUserTimeout sets TCP_USER_TIMEOUT of TCP connections.
+func WithTCPUserTimeout(timeout time.Duration) Option {
+	return Option{}
+}
+
+// WithTCPCongestion sets the congestion control algorithm of TCP connections.
+func WithTCPCongestion(name string) Option {
+	return Option{}
+}
+
+// WithSocketReadBuffer sets SO_RCVBUF of TCP connections.
+func WithSocketReadBuffer(bytes int) Option {
+	return Option{}
+}
+
+// WithSocketWriteBuffer sets SO_SNDBUF of TCP connections.
+func WithSocketWriteBuffer(bytes int) Option {
+	return Option{}
+}
+
+// WithSocketMark sets SO_MARK of TCP connections.
+func WithSocketMark(mark int) Option {
+	return Option{}
+}
+
+// WithOnRequest registers the OnRequest method to the connections created by Dialer.
+func WithOnRequest(onRequest OnRequest) Option {
+	return Option{}
+}
+
+// WithDialTimeout sets the timeout of Dialer.
+func WithDialTimeout(timeout time.Duration) Option {
+	return Option{}
+}
+
+// WithDialLocalAddr sets the local address of Dialer.
+func WithDialLocalAddr(addr net.Addr) Option {
+	return Option{}
+}
+
+// WithDialControl sets the control hook of Dialer.
+func WithDialControl(control func(network, address string, fd int) error) Option {
+	return Option{}
+}
+
+// WithDialResolver sets the resolver used by Dialer.
+func WithDialResolver(resolver Resolver) Option {
+	return Option{}
+}
+
+// WithDialFallbackDelay sets the delay of Happy Eyeballs (RFC 8305) of Dialer.
+func WithDialFallbackDelay(delay time.Duration) Option {
+	return Option{}
+}
+
+// WithTCPFastOpen enables TCP Fast Open.
+func WithTCPFastOpen(queue int) Option {
+	return Option{}
+}
+
+// WithProxyProtocol makes EventLoop parse the PROXY protocol header of the accepted connections.
+func WithProxyProtocol(config ProxyProtocolConfig) Option {
+	return Option{}
+}
+
+// WithDialProxy makes Dialer tunnel the TCP connections through the proxy.
+func WithDialProxy(proxy *url.URL) Option {
+	return Option{}
+}
+
+// WithBufferPolicy sets the sizing policy of the input buffer of connections.
+func WithBufferPolicy(policy BufferPolicy) Option {
+	return Option{}
+}
+
 // NewDialer only support TCP and unix socket now.
-func NewDialer() Dialer {
+func NewDialer(opts ...Option) Dialer {
 	return nil
 }
 
@@ -66,6 +172,6 @@ func ConvertListener(l net.Listener) (nl Listener, err error) {
 }
 
 // CreateListener return a new Listener.
-func CreateListener(network, addr string) (l Listener, err error) {
+func CreateListener(network, addr string, opts ...Option) (l Listener, err error) {
 	return nil, nil
 }
@@ -42,6 +42,27 @@ type Reader interface {
 	// Other behavior is the same as Next.
 	Peek(n int) (buf []byte, err error)
 
+	// NextVec is like Next, but returns the next n bytes as the slices of the underlying nodes,
+	// so the data spanning several nodes is never copied to a contiguous slice.
+	// The slices are only valid until the next call to the Release method,
+	// and they can be forwarded to a Writer by WriteDirect without copying.
+	NextVec(n int) (p [][]byte, err error)
+
+	// PeekVec returns the next n bytes like NextVec without advancing the reader.
+	PeekVec(n int) (p [][]byte, err error)
+
+	// TryNext is the non-blocking Next, which returns ErrWouldBlock immediately if there are fewer than n bytes
+	// but more data may arrive. An error such as ErrEOF is returned instead if no more data will arrive.
+	TryNext(n int) (p []byte, err error)
+
+	// TryPeek is the non-blocking Peek like TryNext.
+	TryPeek(n int) (buf []byte, err error)
+
+	// PeekAtLeast waits for at least min bytes like Peek, and returns all the contiguous data available
+	// in the buffer without copying if it is not less than min bytes, otherwise the next min bytes.
+	// It does not advance the reader.
+	PeekAtLeast(min int) (buf []byte, err error)
+
 	// Skip the next n bytes and advance the reader, which is
 	// a faster implementation of Next when the next data is not used.
 	Skip(n int) (err error)
@@ -53,6 +74,18 @@ type Reader interface {
 	// Until returns err != nil only if line does not end in delim.
 	Until(delim byte) (line []byte, err error)
 
+	// UntilBytes is like Until, but the delimiter is multi-byte, e.g. "\r\n".
+	// The delimiter is searched across the nodes of buffer without flattening it.
+	UntilBytes(delim []byte) (line []byte, err error)
+
+	// UntilBytesLimit is like UntilBytes, but the line including delim is at most limit bytes.
+	// It returns ErrLineTooLong without advancing the reader if delim is not found in the first limit bytes.
+	UntilBytesLimit(delim []byte, limit int) (line []byte, err error)
+
+	// IndexBytes returns the index of the first instance of sep in the readable data, or -1 if sep is not present.
+	// It does not wait for more data.
+	IndexBytes(sep []byte) (index int)
+
 	// ReadString is a faster implementation of Next when a string needs to be returned.
 	// It replaces:
 	//
@@ -101,6 +134,28 @@ type Reader interface {
 
 	// Len returns the total length of the readable data in the reader.
 	Len() (length int)
+
+	// ReadUint16, ReadUint32 and ReadUint64 read the big-endian unsigned integers,
+	// which decode the bytes across nodes directly instead of copying them like Next.
+	// It replaces:
+	//
+	//  var p, err = Next(4)
+	//  return binary.BigEndian.Uint32(p), err
+	//
+	ReadUint16() (v uint16, err error)
+	ReadUint32() (v uint32, err error)
+	ReadUint64() (v uint64, err error)
+
+	// ReadUint16LE, ReadUint32LE and ReadUint64LE read the little-endian unsigned integers.
+	ReadUint16LE() (v uint16, err error)
+	ReadUint32LE() (v uint32, err error)
+	ReadUint64LE() (v uint64, err error)
+
+	// ReadUvarint reads an unsigned varint encoded by binary.PutUvarint.
+	ReadUvarint() (v uint64, err error)
+
+	// ReadVarint reads a signed varint encoded by binary.PutVarint.
+	ReadVarint() (v int64, err error)
 }
 
 // Writer is a collection of operations for nocopy writes.
@@ -190,6 +245,22 @@ type Writer interface {
 
 	// MallocLen returns the total length of the writable data that has not yet been submitted in the writer.
 	MallocLen() (length int)
+
+	// WriteUint16, WriteUint32 and WriteUint64 write the big-endian unsigned integers.
+	WriteUint16(v uint16) (err error)
+	WriteUint32(v uint32) (err error)
+	WriteUint64(v uint64) (err error)
+
+	// WriteUint16LE, WriteUint32LE and WriteUint64LE write the little-endian unsigned integers.
+	WriteUint16LE(v uint16) (err error)
+	WriteUint32LE(v uint32) (err error)
+	WriteUint64LE(v uint64) (err error)
+
+	// WriteUvarint writes an unsigned varint like binary.PutUvarint.
+	WriteUvarint(v uint64) (err error)
+
+	// WriteVarint writes a signed varint like binary.PutVarint.
+	WriteVarint(v int64) (err error)
 }
 
 // ReadWriter is a combination of Reader and Writer.
@@ -199,6 +270,7 @@ type ReadWriter interface {
 }
 
 // NewReader convert io.Reader to nocopy Reader
+// TryNext and TryPeek of the Reader block like Next and Peek, since io.Reader cannot be read without blocking.
 func NewReader(r io.Reader) Reader {
 	return newZCReader(r)
 }
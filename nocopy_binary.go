@@ -0,0 +1,159 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errVarintOverflow = errors.New("varint overflows a 64-bit integer")
+
+// ReadUint16 implements Reader.
+func (b *LinkBuffer) ReadUint16() (v uint16, err error) {
+	u, err := b.readUint(2, false)
+	return uint16(u), err
+}
+
+// ReadUint32 implements Reader.
+func (b *LinkBuffer) ReadUint32() (v uint32, err error) {
+	u, err := b.readUint(4, false)
+	return uint32(u), err
+}
+
+// ReadUint64 implements Reader.
+func (b *LinkBuffer) ReadUint64() (v uint64, err error) {
+	return b.readUint(8, false)
+}
+
+// ReadUint16LE implements Reader.
+func (b *LinkBuffer) ReadUint16LE() (v uint16, err error) {
+	u, err := b.readUint(2, true)
+	return uint16(u), err
+}
+
+// ReadUint32LE implements Reader.
+func (b *LinkBuffer) ReadUint32LE() (v uint32, err error) {
+	u, err := b.readUint(4, true)
+	return uint32(u), err
+}
+
+// ReadUint64LE implements Reader.
+func (b *LinkBuffer) ReadUint64LE() (v uint64, err error) {
+	return b.readUint(8, true)
+}
+
+// ReadUvarint implements Reader.
+func (b *LinkBuffer) ReadUvarint() (v uint64, err error) {
+	return b.readUvarint()
+}
+
+// ReadVarint implements Reader.
+func (b *LinkBuffer) ReadVarint() (v int64, err error) {
+	ux, err := b.readUvarint()
+	return zigzagDecode(ux), err
+}
+
+// WriteUint16 implements Writer.
+func (b *LinkBuffer) WriteUint16(v uint16) (err error) {
+	buf, err := b.Malloc(2)
+	if err == nil {
+		binary.BigEndian.PutUint16(buf, v)
+	}
+	return err
+}
+
+// WriteUint32 implements Writer.
+func (b *LinkBuffer) WriteUint32(v uint32) (err error) {
+	buf, err := b.Malloc(4)
+	if err == nil {
+		binary.BigEndian.PutUint32(buf, v)
+	}
+	return err
+}
+
+// WriteUint64 implements Writer.
+func (b *LinkBuffer) WriteUint64(v uint64) (err error) {
+	buf, err := b.Malloc(8)
+	if err == nil {
+		binary.BigEndian.PutUint64(buf, v)
+	}
+	return err
+}
+
+// WriteUint16LE implements Writer.
+func (b *LinkBuffer) WriteUint16LE(v uint16) (err error) {
+	buf, err := b.Malloc(2)
+	if err == nil {
+		binary.LittleEndian.PutUint16(buf, v)
+	}
+	return err
+}
+
+// WriteUint32LE implements Writer.
+func (b *LinkBuffer) WriteUint32LE(v uint32) (err error) {
+	buf, err := b.Malloc(4)
+	if err == nil {
+		binary.LittleEndian.PutUint32(buf, v)
+	}
+	return err
+}
+
+// WriteUint64LE implements Writer.
+func (b *LinkBuffer) WriteUint64LE(v uint64) (err error) {
+	buf, err := b.Malloc(8)
+	if err == nil {
+		binary.LittleEndian.PutUint64(buf, v)
+	}
+	return err
+}
+
+// WriteUvarint implements Writer.
+func (b *LinkBuffer) WriteUvarint(v uint64) (err error) {
+	buf, err := b.Malloc(uvarintSize(v))
+	if err == nil {
+		binary.PutUvarint(buf, v)
+	}
+	return err
+}
+
+// WriteVarint implements Writer.
+func (b *LinkBuffer) WriteVarint(v int64) (err error) {
+	return b.WriteUvarint(zigzagEncode(v))
+}
+
+// uvarintSize returns the encoded size of v.
+func uvarintSize(v uint64) (n int) {
+	for n = 1; v >= 0x80; n++ {
+		v >>= 7
+	}
+	return n
+}
+
+func zigzagEncode(v int64) uint64 {
+	ux := uint64(v) << 1
+	if v < 0 {
+		ux = ^ux
+	}
+	return ux
+}
+
+func zigzagDecode(ux uint64) int64 {
+	v := int64(ux >> 1)
+	if ux&1 != 0 {
+		v = ^v
+	}
+	return v
+}
@@ -0,0 +1,246 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build netpoll_debug
+// +build netpoll_debug
+
+package netpoll
+
+import (
+	"fmt"
+	"math/bits"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// debugMode is enabled by the build tag netpoll_debug, which makes LinkBuffer detect the use of its memory after release:
+//  1. the buffers are allocated by mmap, and the slices returned by Next, Peek, NextVec and PeekVec are tracked
+//     with the stack traces of the callers.
+//  2. the released buffers are poisoned by debugPoison and protected by mprotect(PROT_NONE) instead of being recycled.
+//  3. passing a released slice to netpoll panics with the stack traces of where it is returned and released,
+//     which is checked by Writer, Read and Write of Connection, the delimiters of Reader and the unix messages.
+//  4. accessing a released slice directly, e.g. by indexing or copy, faults. The goroutines running OnRequest enable
+//     debug.SetPanicOnFault, so the fault panics with the same stack traces; other goroutines crash on the fault
+//     unless they enable debug.SetPanicOnFault themselves.
+//
+// The buffers are not protected on windows, where accessing them reads debugPoison.
+// It costs a lot of CPU and memory, and should never be used in production.
+const debugMode = true
+
+// debugPoison fills the released buffers, so the data read from them after release is obviously invalid.
+const debugPoison = 0xdd
+
+// debugReleasedMax limits the total size of the released buffers kept for checking.
+const debugReleasedMax = 64 * 1024 * 1024
+
+type debugSlice struct {
+	off, len int
+	stack    []uintptr
+}
+
+type debugBuffer struct {
+	buf     []byte
+	mem     []byte // the mapped memory of buf, which is nil if buf is not mapped.
+	slices  []debugSlice
+	release []uintptr
+}
+
+var debugState struct {
+	sync.Mutex
+	// outstanding is the tracked slices indexed by the base address of their buffers.
+	outstanding map[uintptr]*debugBuffer
+	// mapped is the mapped memory of the buffers which are not released, indexed by their base addresses.
+	mapped map[uintptr][]byte
+	// released is the buffers released recently, which are not reused until they are dropped from it.
+	released     []*debugBuffer
+	releasedSize int
+}
+
+// debugMalloc allocates a buffer by mmap, so it can be protected after release.
+// The capacity is up-aligned to the power of 2 as mcache does.
+func debugMalloc(size, capacity int) []byte {
+	if capacity == 0 {
+		return make([]byte, size, capacity)
+	}
+	if capacity <= mallocMax {
+		capacity = 1 << bits.Len(uint(capacity-1))
+	}
+	mem, err := debugMap(capacity)
+	if err != nil {
+		return make([]byte, size, capacity)
+	}
+	debugState.Lock()
+	defer debugState.Unlock()
+	if debugState.mapped == nil {
+		debugState.mapped = make(map[uintptr][]byte)
+	}
+	debugState.mapped[debugAddr(mem)] = mem
+	return mem[:size:capacity]
+}
+
+// debugTrack records the slice p of the buffer owned by node, or the cache buffer p itself if node is nil.
+func debugTrack(node *linkBufferNode, p []byte) {
+	var buf = p
+	if node != nil {
+		if node.origin != nil {
+			node = node.origin
+		}
+		// the buffers of readonly nodes are not released by LinkBuffer.
+		if node.readonly {
+			return
+		}
+		buf = node.buf
+	}
+	if cap(buf) == 0 || cap(p) == 0 {
+		return
+	}
+	var base = debugAddr(buf)
+	var slice = debugSlice{off: int(debugAddr(p) - base), len: len(p), stack: debugStack()}
+	debugState.Lock()
+	defer debugState.Unlock()
+	if debugState.outstanding == nil {
+		debugState.outstanding = make(map[uintptr]*debugBuffer)
+	}
+	var db = debugState.outstanding[base]
+	if db == nil {
+		db = &debugBuffer{buf: buf[:cap(buf)]}
+		debugState.outstanding[base] = db
+	}
+	db.slices = append(db.slices, slice)
+}
+
+// debugFree poisons and protects the released buffer, and keeps it for checking instead of recycling it.
+func debugFree(buf []byte) {
+	if cap(buf) == 0 {
+		return
+	}
+	buf = buf[:cap(buf)]
+	for i := range buf {
+		buf[i] = debugPoison
+	}
+	var base = debugAddr(buf)
+	debugState.Lock()
+	defer debugState.Unlock()
+	var db = debugState.outstanding[base]
+	if db == nil {
+		db = &debugBuffer{buf: buf}
+	}
+	delete(debugState.outstanding, base)
+	if mem, ok := debugState.mapped[base]; ok {
+		delete(debugState.mapped, base)
+		if debugProtect(mem) == nil {
+			db.mem = mem
+		}
+	}
+	db.release = debugStack()
+	debugState.released = append(debugState.released, db)
+	debugState.releasedSize += cap(buf)
+	for debugState.releasedSize > debugReleasedMax && len(debugState.released) > 1 {
+		var dropped = debugState.released[0]
+		debugState.releasedSize -= cap(dropped.buf)
+		if dropped.mem != nil {
+			debugUnmap(dropped.mem)
+		}
+		debugState.released[0] = nil
+		debugState.released = debugState.released[1:]
+	}
+}
+
+// debugCheck panics if p refers to a released buffer.
+func debugCheck(p []byte) {
+	if cap(p) == 0 {
+		return
+	}
+	debugReport(debugAddr(p), fmt.Sprintf("use of a slice[%d]", len(p)))
+}
+
+// debugPanicOnFault makes the faults of the current goroutine panic, and returns the previous setting.
+func debugPanicOnFault() bool {
+	return debug.SetPanicOnFault(true)
+}
+
+// debugRecoverFault restores the setting of debugPanicOnFault, and panics with the stack traces of the released
+// buffer if the recovered panic is a fault on it.
+func debugRecoverFault(old bool) {
+	debug.SetPanicOnFault(old)
+	var r = recover()
+	if r == nil {
+		return
+	}
+	if fault, ok := r.(interface{ Addr() uintptr }); ok {
+		debugReport(fault.Addr(), fmt.Sprintf("access to 0x%x", fault.Addr()))
+	}
+	panic(r)
+}
+
+// debugReport panics if addr is in a released buffer.
+func debugReport(addr uintptr, use string) {
+	debugState.Lock()
+	defer debugState.Unlock()
+	for i := len(debugState.released) - 1; i >= 0; i-- {
+		var db = debugState.released[i]
+		var base, size = debugAddr(db.buf), uintptr(cap(db.buf))
+		if db.mem != nil {
+			size = uintptr(cap(db.mem))
+		}
+		if addr < base || addr >= base+size {
+			continue
+		}
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "netpoll: %s after its buffer is released", use)
+		var off = int(addr - base)
+		for _, s := range db.slices {
+			if off >= s.off && off < s.off+s.len {
+				sb.WriteString("\n\nthe slice is returned by:\n")
+				sb.WriteString(debugFormat(s.stack))
+				break
+			}
+		}
+		sb.WriteString("\n\nthe buffer is released by:\n")
+		sb.WriteString(debugFormat(db.release))
+		panic(sb.String())
+	}
+}
+
+// debugDoubleRelease panics when a node is released more times than it is referred.
+func debugDoubleRelease() {
+	panic("netpoll: linkBufferNode is released twice, at:\n" + debugFormat(debugStack()))
+}
+
+func debugAddr(p []byte) uintptr {
+	return uintptr(unsafe.Pointer(&p[:cap(p)][0]))
+}
+
+func debugStack() []uintptr {
+	var pcs [32]uintptr
+	// skip runtime.Callers, debugStack and the debug function.
+	var n = runtime.Callers(3, pcs[:])
+	return append([]uintptr(nil), pcs[:n]...)
+}
+
+func debugFormat(stack []uintptr) string {
+	var sb strings.Builder
+	var frames = runtime.CallersFrames(stack)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&sb, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return sb.String()
+}
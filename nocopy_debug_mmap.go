@@ -0,0 +1,35 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build netpoll_debug && !windows
+// +build netpoll_debug,!windows
+
+package netpoll
+
+import "syscall"
+
+// debugMap maps anonymous memory of size bytes for a debug buffer.
+func debugMap(size int) ([]byte, error) {
+	return syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+}
+
+// debugProtect makes the memory inaccessible, so any access to it faults.
+func debugProtect(mem []byte) error {
+	return syscall.Mprotect(mem, syscall.PROT_NONE)
+}
+
+// debugUnmap unmaps the memory returned by debugMap.
+func debugUnmap(mem []byte) error {
+	return syscall.Munmap(mem)
+}
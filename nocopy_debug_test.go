@@ -0,0 +1,148 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build netpoll_debug
+// +build netpoll_debug
+
+package netpoll
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestLinkBufferDebug(t *testing.T) {
+	buf := NewLinkBuffer()
+	b, _ := buf.Malloc(1024)
+	copy(b, "hello")
+	MustNil(t, buf.Flush())
+	p, err := buf.Next(1024)
+	MustNil(t, err)
+	Equal(t, string(p[:5]), "hello")
+	MustNil(t, buf.Close())
+	// accessing the released buffer panics on the fault
+	msg := catchPanic(func() {
+		defer debugRecoverFault(debugPanicOnFault())
+		copy(make([]byte, 1), p)
+	})
+	MustTrue(t, strings.Contains(msg, "after its buffer is released"))
+	MustTrue(t, strings.Contains(msg, "the slice is returned by:\ngithub.com/cloudwego/netpoll.(*LinkBuffer).Next"))
+
+	msg = catchPanic(func() {
+		out := NewLinkBuffer()
+		out.WriteBinary(p[10:20])
+	})
+	MustTrue(t, strings.Contains(msg, "use of a slice[10] after its buffer is released"))
+	MustTrue(t, strings.Contains(msg, "the slice is returned by:\ngithub.com/cloudwego/netpoll.(*LinkBuffer).Next"))
+	MustTrue(t, strings.Contains(msg, "the buffer is released by:"))
+	MustTrue(t, strings.Contains(msg, "TestLinkBufferDebug"))
+
+	// the slices which are not released can be written
+	buf = NewLinkBuffer()
+	buf.WriteString("world")
+	buf.Flush()
+	p, _ = buf.Next(5)
+	out := NewLinkBuffer()
+	out.WriteBinary(p)
+	out.Flush()
+	Equal(t, string(out.Bytes()), "world")
+
+	node := newLinkBufferNode(16)
+	node.Release()
+	msg = catchPanic(func() {
+		node.Release()
+	})
+	MustTrue(t, strings.Contains(msg, "released twice"))
+}
+
+func TestLinkBufferDebugReadAfterRelease(t *testing.T) {
+	buf := NewLinkBuffer()
+	b, _ := buf.Malloc(LinkBufferCap)
+	copy(b, "hello")
+	buf.Flush()
+	p, err := buf.Next(LinkBufferCap)
+	MustNil(t, err)
+	// the read node is released once the following data arrives
+	buf.Malloc(1)
+	buf.Flush()
+	MustNil(t, buf.Release())
+	p = p[:5]
+	// reading the released slice directly panics on the fault
+	msg := catchPanic(func() {
+		defer debugRecoverFault(debugPanicOnFault())
+		_ = bytes.Equal(p, []byte("hello"))
+	})
+	MustTrue(t, strings.Contains(msg, "after its buffer is released"))
+	MustTrue(t, strings.Contains(msg, "TestLinkBufferDebugReadAfterRelease"))
+
+	// passing it to netpoll panics
+	msg = catchPanic(func() {
+		buf.IndexBytes(p)
+	})
+	MustTrue(t, strings.Contains(msg, "use of a slice[5] after its buffer is released"))
+	msg = catchPanic(func() {
+		buf.UntilBytes(p[:1])
+	})
+	MustTrue(t, strings.Contains(msg, "use of a slice[1] after its buffer is released"))
+
+	r, w := GetSysFdPairs()
+	defer syscall.Close(r)
+	var conn = &connection{}
+	conn.init(&netFD{fd: w}, nil)
+	defer conn.Close()
+	msg = catchPanic(func() {
+		conn.Write(p)
+	})
+	MustTrue(t, strings.Contains(msg, "use of a slice[5] after its buffer is released"))
+}
+
+func TestConnectionDebugPanicOnFault(t *testing.T) {
+	var faulted = make(chan bool, 1)
+	var opts = &options{onRequest: func(ctx context.Context, connection Connection) error {
+		connection.Reader().Skip(connection.Reader().Len())
+		buf := NewLinkBuffer()
+		buf.WriteString("hello")
+		buf.Flush()
+		p, _ := buf.Next(5)
+		buf.Close()
+		// the fault in OnRequest panics instead of crashing
+		defer func() {
+			_, ok := recover().(interface{ Addr() uintptr })
+			faulted <- ok
+		}()
+		copy(make([]byte, 1), p)
+		return nil
+	}}
+	r, w := GetSysFdPairs()
+	var rconn, wconn = &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, opts)
+	wconn.init(&netFD{fd: w}, nil)
+	defer rconn.Close()
+	defer wconn.Close()
+	_, err := wconn.WriteString("hello")
+	MustNil(t, err)
+	MustNil(t, wconn.Flush())
+	MustTrue(t, <-faulted)
+}
+
+func catchPanic(f func()) (msg string) {
+	defer func() {
+		msg, _ = recover().(string)
+	}()
+	f()
+	return ""
+}
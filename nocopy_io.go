@@ -0,0 +1,86 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"io"
+)
+
+var (
+	_ io.WriterTo   = &LinkBuffer{}
+	_ io.ReaderFrom = &LinkBuffer{}
+)
+
+// WriteTo implements io.WriterTo, which writes the readable nodes to w directly and releases them.
+func (b *LinkBuffer) WriteTo(w io.Writer) (n int64, err error) {
+	return writeTo(b, w)
+}
+
+// ReadFrom implements io.ReaderFrom, which reads data from r until EOF and flushes it.
+func (b *LinkBuffer) ReadFrom(r io.Reader) (n int64, err error) {
+	return readFrom(b, r)
+}
+
+// writeTo writes the readable data of r to w node by node, and releases it.
+func writeTo(r Reader, w io.Writer) (n int64, err error) {
+	for r.Len() > 0 {
+		p, err := r.PeekAtLeast(1)
+		if err != nil {
+			return n, err
+		}
+		m, err := w.Write(p)
+		if m > 0 {
+			n += int64(m)
+			r.Skip(m)
+		}
+		if err == nil && m < len(p) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			r.Release()
+			return n, err
+		}
+	}
+	return n, r.Release()
+}
+
+// readFrom reads data from r into w until EOF, and flushes every chunk.
+func readFrom(w Writer, r io.Reader) (n int64, err error) {
+	for {
+		buf, err := w.Malloc(pagesize)
+		if err != nil {
+			return n, err
+		}
+		m, rerr := r.Read(buf)
+		if m < 0 {
+			m = 0
+		}
+		if err = w.MallocAck(m); err != nil {
+			return n, err
+		}
+		if m > 0 {
+			n += int64(m)
+			if err = w.Flush(); err != nil {
+				return n, err
+			}
+		}
+		if rerr == io.EOF {
+			return n, nil
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+	}
+}
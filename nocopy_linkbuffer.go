@@ -19,9 +19,11 @@ package netpoll
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"reflect"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"unsafe"
@@ -34,17 +36,23 @@ import (
 const BinaryInplaceThreshold = block4k
 
 // LinkBufferCap that can be modified marks the minimum value of each node of LinkBuffer.
+// The input buffer of connections is not limited by it, which is sized by BufferPolicy.InitBufferSize.
 var LinkBufferCap = block4k
 
 // NewLinkBuffer size defines the initial capacity, but there is no readable data.
 func NewLinkBuffer(size ...int) *LinkBuffer {
-	var buf = &LinkBuffer{}
 	var l int
 	if len(size) > 0 {
 		l = size[0]
 	}
-	var node = newLinkBufferNode(l)
+	return newLinkBuffer(newLinkBufferNode(l))
+}
+
+// newLinkBuffer creates a LinkBuffer starting with node.
+func newLinkBuffer(node *linkBufferNode) *LinkBuffer {
+	var buf = &LinkBuffer{}
 	buf.head, buf.read, buf.flush, buf.write = node, node, node, node
+	buf.capacity = node.capacity()
 	return buf
 }
 
@@ -59,6 +67,8 @@ type LinkBuffer struct {
 	write *linkBufferNode // malloc tail
 
 	caches [][]byte // buf allocated by Next when cross-package, which should be freed when release
+
+	capacity int64 // the sum of the capacities of the buffers owned by the nodes and caches
 }
 
 var _ Reader = &LinkBuffer{}
@@ -70,6 +80,12 @@ func (b *LinkBuffer) Len() int {
 	return int(l)
 }
 
+// totalCap returns the sum of the capacities of the buffers held by this LinkBuffer, including the read data
+// which is not released yet.
+func (b *LinkBuffer) totalCap() int {
+	return int(atomic.LoadInt64(&b.capacity))
+}
+
 // IsEmpty check if this LinkBuffer is empty.
 func (b *LinkBuffer) IsEmpty() (ok bool) {
 	return b.Len() == 0
@@ -90,13 +106,21 @@ func (b *LinkBuffer) Next(n int) (p []byte, err error) {
 
 	// single node
 	if b.isSingleNode(n) {
-		return b.read.Next(n), nil
+		p = b.read.Next(n)
+		if debugMode {
+			debugTrack(b.read, p)
+		}
+		return p, nil
 	}
 	// multiple nodes
 	var pIdx int
 	if block1k < n && n <= mallocMax {
 		p = malloc(n, n)
 		b.caches = append(b.caches, p)
+		atomic.AddInt64(&b.capacity, int64(cap(p)))
+		if debugMode {
+			debugTrack(nil, p)
+		}
 	} else {
 		p = make([]byte, n)
 	}
@@ -127,13 +151,21 @@ func (b *LinkBuffer) Peek(n int) (p []byte, err error) {
 	}
 	// single node
 	if b.isSingleNode(n) {
-		return b.read.Peek(n), nil
+		p = b.read.Peek(n)
+		if debugMode {
+			debugTrack(b.read, p)
+		}
+		return p, nil
 	}
 	// multiple nodes
 	var pIdx int
 	if block1k < n && n <= mallocMax {
 		p = malloc(n, n)
 		b.caches = append(b.caches, p)
+		atomic.AddInt64(&b.capacity, int64(cap(p)))
+		if debugMode {
+			debugTrack(nil, p)
+		}
 	} else {
 		p = make([]byte, n)
 	}
@@ -153,6 +185,98 @@ func (b *LinkBuffer) Peek(n int) (p []byte, err error) {
 	return p, nil
 }
 
+// TryNext implements Reader.
+func (b *LinkBuffer) TryNext(n int) (p []byte, err error) {
+	if b.Len() < n {
+		return p, Exception(ErrWouldBlock, fmt.Sprintf("link buffer try next[%d]", n))
+	}
+	return b.Next(n)
+}
+
+// TryPeek implements Reader.
+func (b *LinkBuffer) TryPeek(n int) (p []byte, err error) {
+	if b.Len() < n {
+		return p, Exception(ErrWouldBlock, fmt.Sprintf("link buffer try peek[%d]", n))
+	}
+	return b.Peek(n)
+}
+
+// PeekAtLeast implements Reader.
+func (b *LinkBuffer) PeekAtLeast(min int) (p []byte, err error) {
+	if l := b.Len(); l > 0 && l >= min {
+		// skip the empty nodes
+		b.isSingleNode(1)
+		if l = b.read.Len(); l >= min {
+			p = b.read.Peek(l)
+			if debugMode {
+				debugTrack(b.read, p)
+			}
+			return p, nil
+		}
+	}
+	// multiple nodes
+	return b.Peek(min)
+}
+
+// NextVec implements Reader.
+func (b *LinkBuffer) NextVec(n int) (p [][]byte, err error) {
+	if n <= 0 {
+		return
+	}
+	// check whether enough or not.
+	if b.Len() < n {
+		return p, fmt.Errorf("link buffer next vec[%d] not enough", n)
+	}
+	b.recalLen(-n) // re-cal length
+
+	var l int
+	for ack := n; ack > 0; ack = ack - l {
+		l = b.read.Len()
+		if l >= ack {
+			p = append(p, b.read.Next(ack))
+		} else if l > 0 {
+			p = append(p, b.read.Next(l))
+		}
+		if debugMode && l > 0 {
+			debugTrack(b.read, p[len(p)-1])
+		}
+		if l >= ack {
+			break
+		}
+		b.read = b.read.next
+	}
+	return p, nil
+}
+
+// PeekVec implements Reader.
+func (b *LinkBuffer) PeekVec(n int) (p [][]byte, err error) {
+	if n <= 0 {
+		return
+	}
+	// check whether enough or not.
+	if b.Len() < n {
+		return p, fmt.Errorf("link buffer peek vec[%d] not enough", n)
+	}
+	var node = b.read
+	var l int
+	for ack := n; ack > 0; ack = ack - l {
+		l = node.Len()
+		if l >= ack {
+			p = append(p, node.Peek(ack))
+		} else if l > 0 {
+			p = append(p, node.Peek(l))
+		}
+		if debugMode && l > 0 {
+			debugTrack(node, p[len(p)-1])
+		}
+		if l >= ack {
+			break
+		}
+		node = node.next
+	}
+	return p, nil
+}
+
 // Skip implements Reader.
 func (b *LinkBuffer) Skip(n int) (err error) {
 	if n <= 0 {
@@ -185,9 +309,11 @@ func (b *LinkBuffer) Release() (err error) {
 	for b.head != b.read {
 		node := b.head
 		b.head = b.head.next
+		atomic.AddInt64(&b.capacity, -node.capacity())
 		node.Release()
 	}
 	for i := range b.caches {
+		atomic.AddInt64(&b.capacity, -int64(cap(b.caches[i])))
 		free(b.caches[i])
 		b.caches[i] = nil
 	}
@@ -261,6 +387,93 @@ func (b *LinkBuffer) ReadByte() (p byte, err error) {
 	}
 }
 
+// readUint reads n bytes (at most 8) as an unsigned integer in big-endian or little-endian,
+// which decodes the bytes across nodes directly without copying them to a cache slice.
+func (b *LinkBuffer) readUint(n int, littleEndian bool) (v uint64, err error) {
+	// check whether enough or not.
+	if b.Len() < n {
+		return v, fmt.Errorf("link buffer read uint[%d] not enough", n)
+	}
+	b.recalLen(-n) // re-cal length
+	for i := 0; i < n; {
+		l := b.read.Len()
+		if l == 0 {
+			b.read = b.read.next
+			continue
+		}
+		if l > n-i {
+			l = n - i
+		}
+		for _, c := range b.read.Next(l) {
+			if littleEndian {
+				v |= uint64(c) << (8 * uint(i))
+			} else {
+				v = v<<8 | uint64(c)
+			}
+			i++
+		}
+	}
+	return v, nil
+}
+
+// readUvarint reads an unsigned varint across nodes.
+func (b *LinkBuffer) readUvarint() (v uint64, err error) {
+	v, n := b.uvarint()
+	if n == 0 {
+		return 0, errors.New("link buffer read uvarint not enough")
+	}
+	if n < 0 {
+		return 0, errVarintOverflow
+	}
+	b.recalLen(-n) // re-cal length
+
+	var l int
+	for ack := n; ack > 0; ack = ack - l {
+		l = b.read.Len()
+		if l >= ack {
+			b.read.off += ack
+			break
+		}
+		b.read = b.read.next
+	}
+	return v, nil
+}
+
+// uvarintLen returns the length of the varint at the beginning of the buffer,
+// 0 if the buffer is too small, or a negative number if the value overflows a 64-bit integer.
+func (b *LinkBuffer) uvarintLen() (n int) {
+	_, n = b.uvarint()
+	return n
+}
+
+// uvarint decodes the varint at the beginning of the buffer without consuming it, like binary.Uvarint.
+func (b *LinkBuffer) uvarint() (v uint64, n int) {
+	var s uint
+	var unread = b.Len()
+	for node := b.read; unread > 0; node = node.next {
+		p := node.Peek(node.Len())
+		if len(p) > unread {
+			p = p[:unread]
+		}
+		unread -= len(p)
+		for _, c := range p {
+			if n == binary.MaxVarintLen64 {
+				return 0, -(n + 1) // overflow
+			}
+			if c < 0x80 {
+				if n == binary.MaxVarintLen64-1 && c > 1 {
+					return 0, -(n + 1) // overflow
+				}
+				return v | uint64(c)<<s, n + 1
+			}
+			v |= uint64(c&0x7f) << s
+			s += 7
+			n++
+		}
+	}
+	return 0, 0
+}
+
 // Until returns a slice ends with the delim in the buffer.
 func (b *LinkBuffer) Until(delim byte) (line []byte, err error) {
 	n := b.indexByte(delim, 0)
@@ -270,6 +483,32 @@ func (b *LinkBuffer) Until(delim byte) (line []byte, err error) {
 	return b.Next(n + 1)
 }
 
+// UntilBytes returns a slice ends with the delim in the buffer.
+func (b *LinkBuffer) UntilBytes(delim []byte) (line []byte, err error) {
+	n := b.indexBytes(delim, 0)
+	if n < 0 {
+		return nil, fmt.Errorf("link buffer read slice cannot find: %q", delim)
+	}
+	return b.Next(n + len(delim))
+}
+
+// UntilBytesLimit returns a slice ends with the delim in the first limit bytes of the buffer.
+func (b *LinkBuffer) UntilBytesLimit(delim []byte, limit int) (line []byte, err error) {
+	n := b.indexBytes(delim, 0)
+	if n >= 0 && n+len(delim) <= limit {
+		return b.Next(n + len(delim))
+	}
+	if n >= 0 || b.Len() >= limit {
+		return nil, Exception(ErrLineTooLong, fmt.Sprintf("limit[%d]", limit))
+	}
+	return nil, fmt.Errorf("link buffer read slice cannot find: %q", delim)
+}
+
+// IndexBytes implements Reader.
+func (b *LinkBuffer) IndexBytes(sep []byte) (index int) {
+	return b.indexBytes(sep, 0)
+}
+
 // Slice returns a new LinkBuffer, which is a zero-copy slice of this LinkBuffer,
 // and only holds the ability of Reader.
 //
@@ -404,6 +643,9 @@ func (b *LinkBuffer) WriteBuffer(buf *LinkBuffer) (err error) {
 	if bufLen+bufMallocLen <= 0 {
 		return nil
 	}
+	for node := buf.read; node != buf.write.next; node = node.next {
+		atomic.AddInt64(&b.capacity, node.capacity())
+	}
 	b.write.next = buf.read
 	b.write = buf.write
 
@@ -419,6 +661,7 @@ func (b *LinkBuffer) WriteBuffer(buf *LinkBuffer) (err error) {
 		nd.Release()
 	}
 	buf.length, buf.mallocSize, buf.head, buf.read, buf.flush, buf.write = 0, 0, nil, nil, nil, nil
+	atomic.StoreInt64(&buf.capacity, 0)
 
 	// DON'T MODIFY THE CODE BELOW UNLESS YOU KNOW WHAT YOU ARE DOING !
 	//
@@ -445,6 +688,9 @@ func (b *LinkBuffer) WriteString(s string) (n int, err error) {
 
 // WriteBinary implements Writer.
 func (b *LinkBuffer) WriteBinary(p []byte) (n int, err error) {
+	if debugMode {
+		debugCheck(p)
+	}
 	n = len(p)
 	if n == 0 {
 		return
@@ -468,6 +714,9 @@ func (b *LinkBuffer) WriteBinary(p []byte) (n int, err error) {
 
 // WriteDirect cannot be mixed with WriteString or WriteBinary functions.
 func (b *LinkBuffer) WriteDirect(p []byte, remainLen int) error {
+	if debugMode {
+		debugCheck(p)
+	}
 	n := len(p)
 	if n == 0 || remainLen < 0 {
 		return nil
@@ -475,7 +724,7 @@ func (b *LinkBuffer) WriteDirect(p []byte, remainLen int) error {
 	// find origin
 	origin := b.flush
 	malloc := b.mallocSize - remainLen // calculate the remaining malloc length
-	for t := origin.malloc - len(origin.buf); t <= malloc; t = origin.malloc - len(origin.buf) {
+	for t := origin.malloc - len(origin.buf); t < malloc; t = origin.malloc - len(origin.buf) {
 		malloc -= t
 		origin = origin.next
 	}
@@ -529,6 +778,7 @@ func (b *LinkBuffer) Close() (err error) {
 		nd.Release()
 	}
 	b.head, b.read, b.flush, b.write = nil, nil, nil, nil
+	atomic.StoreInt64(&b.capacity, 0)
 	return nil
 }
 
@@ -572,14 +822,15 @@ func (b *LinkBuffer) GetBytes(p [][]byte) (vs [][]byte) {
 //
 // bookSize: The size of data that can be read at once.
 // maxSize: The maximum size of data between two Release(). In some cases, this can
-// 	guarantee all data allocated in one node to reduce copy.
+// 	guarantee all data allocated in one node to reduce copy. It is not limited by LinkBufferCap.
 func (b *LinkBuffer) book(bookSize, maxSize int) (p []byte) {
 	l := cap(b.write.buf) - b.write.malloc
 	// grow linkBuffer
 	if l == 0 {
 		l = maxSize
-		b.write.next = newLinkBufferNode(maxSize)
+		b.write.next = newSizedLinkBufferNode(maxSize)
 		b.write = b.write.next
+		atomic.AddInt64(&b.capacity, b.write.capacity())
 	}
 	if l > bookSize {
 		l = bookSize
@@ -587,6 +838,22 @@ func (b *LinkBuffer) book(bookSize, maxSize int) (p []byte) {
 	return b.write.Malloc(l)
 }
 
+// bookAtLeast is like book, but the returned buffer is at least n bytes,
+// which is used to read a whole message at once.
+func (b *LinkBuffer) bookAtLeast(n, maxSize int) (p []byte) {
+	l := cap(b.write.buf) - b.write.malloc
+	if l < n {
+		if maxSize < n {
+			maxSize = n
+		}
+		l = maxSize
+		b.write.next = newSizedLinkBufferNode(maxSize)
+		b.write = b.write.next
+		atomic.AddInt64(&b.capacity, b.write.capacity())
+	}
+	return b.write.Malloc(l)
+}
+
 // bookAck will ack the first n malloc bytes and discard the rest.
 //
 // length: The size of data in inputBuffer. It is used to calculate the maxSize
@@ -641,11 +908,81 @@ func (b *LinkBuffer) indexByte(c byte, skip int) int {
 	return -1
 }
 
+// indexBytes returns the index of the first instance of sep in buffer, or -1 if sep is not present in buffer.
+// The instance across nodes is found by comparing the following nodes, so the buffer is not flattened.
+func (b *LinkBuffer) indexBytes(sep []byte, skip int) int {
+	if debugMode {
+		debugCheck(sep)
+	}
+	size := b.Len()
+	if skip+len(sep) > size {
+		return -1
+	}
+	if len(sep) == 0 {
+		return skip
+	}
+	var pos int // the index of the current node
+	var unread = size
+	for node := b.read; unread > 0; node = node.next {
+		p := node.Peek(node.Len())
+		if len(p) > unread {
+			p = p[:unread]
+		}
+		unread -= len(p)
+		if skip < pos+len(p) {
+			start := skip - pos
+			if start < 0 {
+				start = 0
+			}
+			// the instance in the current node
+			if i := bytes.Index(p[start:], sep); i >= 0 {
+				return pos + start + i
+			}
+			// the instance begins at the tail of the current node
+			from := len(p) - len(sep) + 1
+			if from < start {
+				from = start
+			}
+			for i := from; i < len(p); i++ {
+				if p[i] == sep[0] && hasPrefixAcross(p[i:], node.next, sep, unread) {
+					return pos + i
+				}
+			}
+		}
+		pos += len(p)
+	}
+	return -1
+}
+
+// hasPrefixAcross reports whether the data beginning with p, which is the tail of a node,
+// and continuing in the following nodes (at most unread bytes) has the prefix sep.
+func hasPrefixAcross(p []byte, next *linkBufferNode, sep []byte, unread int) bool {
+	if !bytes.HasPrefix(sep, p) {
+		return false
+	}
+	sep = sep[len(p):]
+	if len(sep) > unread {
+		return false
+	}
+	for node := next; len(sep) > 0; node = node.next {
+		q := node.Peek(node.Len())
+		if len(q) > len(sep) {
+			q = q[:len(sep)]
+		}
+		if !bytes.HasPrefix(sep, q) {
+			return false
+		}
+		sep = sep[len(q):]
+	}
+	return true
+}
+
 // resetTail will reset tail node or add an empty tail node to
 // guarantee the tail node is not larger than 8KB
 func (b *LinkBuffer) resetTail(maxSize int) {
 	// FIXME: The tail node must not be larger than 8KB to prevent Out Of Memory.
-	if maxSize <= pagesize {
+	// The tail node may be larger than maxSize, if maxSize is shrunk by BufferPolicy.ShrinkAfterIdle.
+	if maxSize <= pagesize && cap(b.write.buf) <= pagesize {
 		b.write.Reset()
 		return
 	}
@@ -657,6 +994,20 @@ func (b *LinkBuffer) resetTail(maxSize int) {
 	return
 }
 
+// trim releases the tail node if it is the only node and nothing has been read from it since the last Release,
+// so that the buffer of an idle connection holds no memory until the next book.
+func (b *LinkBuffer) trim() (ok bool) {
+	var node = b.write
+	if b.Len() != 0 || b.head != node || node.readonly || node.off != 0 || node.malloc != 0 || len(b.caches) != 0 {
+		return false
+	}
+	b.write = newLinkBufferNode(0)
+	b.head, b.read, b.flush = b.write, b.write, b.write
+	atomic.AddInt64(&b.capacity, -node.capacity())
+	node.Release()
+	return true
+}
+
 // recalLen re-calculate the length
 func (b *LinkBuffer) recalLen(delta int) (length int) {
 	return int(atomic.AddInt64(&b.length, int64(delta)))
@@ -667,25 +1018,36 @@ func (b *LinkBuffer) recalLen(delta int) (length int) {
 // newLinkBufferNode create or reuse linkBufferNode.
 // Nodes with size <= 0 are marked as readonly, which means the node.buf is not allocated by this mcache.
 func newLinkBufferNode(size int) *linkBufferNode {
+	if size > 0 && size < LinkBufferCap {
+		size = LinkBufferCap
+	}
+	return newSizedLinkBufferNode(size)
+}
+
+// newSizedLinkBufferNode is like newLinkBufferNode, but the size is not limited by LinkBufferCap,
+// which is used by the input buffer of connections sized by BufferPolicy.
+func newSizedLinkBufferNode(size int) *linkBufferNode {
 	var node = linkedPool.Get().(*linkBufferNode)
+	node.unpool()
 	// reset node offset
 	node.off, node.malloc, node.refer, node.readonly = 0, 0, 1, false
+	atomic.AddInt64(&memStats.nodes, 1)
 	if size <= 0 {
 		node.readonly = true
 		return node
 	}
-	if size < LinkBufferCap {
-		size = LinkBufferCap
-	}
 	node.buf = malloc(0, size)
 	return node
 }
 
 var linkedPool = sync.Pool{
 	New: func() interface{} {
-		return &linkBufferNode{
+		var node = &linkBufferNode{
 			refer: 1, // 自带 1 引用
 		}
+		// the nodes dropped by the pool are no longer counted as pooled.
+		runtime.SetFinalizer(node, (*linkBufferNode).unpool)
+		return node
 	},
 }
 
@@ -695,10 +1057,27 @@ type linkBufferNode struct {
 	malloc   int             // write-offset
 	refer    int32           // reference count
 	readonly bool            // read-only node, introduced by Refer, WriteString, WriteBinary, etc., default false
+	pooled   bool            // whether the node is in linkedPool
 	origin   *linkBufferNode // the root node of the extends
 	next     *linkBufferNode // the next node of the linked buffer
 }
 
+// capacity returns the capacity of the buffer owned by the node, which is 0 for readonly nodes.
+func (node *linkBufferNode) capacity() int64 {
+	if node.readonly {
+		return 0
+	}
+	return int64(cap(node.buf))
+}
+
+// unpool removes the node from the count of the pooled nodes.
+func (node *linkBufferNode) unpool() {
+	if node.pooled {
+		node.pooled = false
+		atomic.AddInt64(&memStats.pooled, -1)
+	}
+}
+
 func (node *linkBufferNode) Len() (l int) {
 	return len(node.buf) - node.off
 }
@@ -708,7 +1087,8 @@ func (node *linkBufferNode) IsEmpty() (ok bool) {
 }
 
 func (node *linkBufferNode) Reset() {
-	if node.origin != nil || atomic.LoadInt32(&node.refer) != 1 {
+	// the buffer is never reused in debug mode, since the slices read from it may be still in use.
+	if debugMode || node.origin != nil || atomic.LoadInt32(&node.refer) != 1 {
 		return
 	}
 	node.off, node.malloc = 0, 0
@@ -755,13 +1135,22 @@ func (node *linkBufferNode) Release() (err error) {
 		node.origin.Release()
 	}
 	// release self
-	if atomic.AddInt32(&node.refer, -1) == 0 {
+	refer := atomic.AddInt32(&node.refer, -1)
+	if refer == 0 {
 		// readonly nodes cannot recycle node.buf, other node.buf are recycled to mcache.
 		if !node.readonly {
 			free(node.buf)
 		}
 		node.buf, node.origin, node.next = nil, nil, nil
-		linkedPool.Put(node)
+		atomic.AddInt64(&memStats.nodes, -1)
+		// the node is not reused in debug mode to detect releasing twice.
+		if !debugMode {
+			node.pooled = true
+			atomic.AddInt64(&memStats.pooled, 1)
+			linkedPool.Put(node)
+		}
+	} else if debugMode && refer < 0 {
+		debugDoubleRelease()
 	}
 	return nil
 }
@@ -778,6 +1167,7 @@ func (b *LinkBuffer) growth(n int) {
 		if b.write.next == nil {
 			b.write.next = newLinkBufferNode(n)
 			b.write = b.write.next
+			atomic.AddInt64(&b.capacity, b.write.capacity())
 			return
 		}
 		b.write = b.write.next
@@ -817,15 +1207,25 @@ func unsafeStringToSlice(s string) (b []byte) {
 const mallocMax = block8k * block1k
 
 // malloc limits the cap of the buffer from mcache.
-func malloc(size, capacity int) []byte {
-	if capacity > mallocMax {
-		return make([]byte, size, capacity)
+func malloc(size, capacity int) (buf []byte) {
+	if debugMode {
+		buf = debugMalloc(size, capacity)
+	} else if capacity > mallocMax {
+		buf = make([]byte, size, capacity)
+	} else {
+		buf = mcache.Malloc(size, capacity)
 	}
-	return mcache.Malloc(size, capacity)
+	memAlloc(cap(buf))
+	return buf
 }
 
 // free limits the cap of the buffer from mcache.
 func free(buf []byte) {
+	memFree(cap(buf))
+	if debugMode {
+		debugFree(buf)
+		return
+	}
 	if cap(buf) > mallocMax {
 		return
 	}
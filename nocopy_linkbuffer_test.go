@@ -19,7 +19,10 @@ package netpoll
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
 	"sync/atomic"
 	"testing"
 )
@@ -367,6 +370,56 @@ func TestWriteBuffer(t *testing.T) {
 	MustTrue(t, bytes.Equal(buf1.Bytes(), []byte{2, 3}))
 }
 
+func TestLinkBufferTotalCap(t *testing.T) {
+	// clean & new
+	LinkBufferCap = 32
+
+	var check = func(b *LinkBuffer) {
+		t.Helper()
+		var sum int
+		for node := b.head; node != nil; node = node.next {
+			sum += int(node.capacity())
+		}
+		for _, p := range b.caches {
+			sum += cap(p)
+		}
+		Equal(t, b.totalCap(), sum)
+	}
+	var buf = NewLinkBuffer()
+	check(buf)
+	buf.Malloc(100)
+	buf.WriteBinary(make([]byte, BinaryInplaceThreshold+1))
+	buf.Malloc(20)
+	buf.WriteDirect([]byte("direct"), 10)
+	buf.Flush()
+	check(buf)
+	MustTrue(t, buf.totalCap() >= 120)
+
+	// the data read across nodes is cached until released
+	_, err := buf.Next(block1k + 200)
+	MustNil(t, err)
+	check(buf)
+	MustNil(t, buf.Release())
+	check(buf)
+
+	var other = NewLinkBuffer()
+	other.Malloc(64)
+	other.Flush()
+	MustNil(t, buf.WriteBuffer(other))
+	buf.Flush()
+	check(buf)
+	Equal(t, other.totalCap(), 0)
+
+	r, err := buf.Slice(buf.Len())
+	MustNil(t, err)
+	check(buf)
+	Equal(t, r.(*LinkBuffer).totalCap(), 0)
+	MustNil(t, r.Release())
+
+	MustNil(t, buf.Close())
+	Equal(t, buf.totalCap(), 0)
+}
+
 func TestWriteMultiFlush(t *testing.T) {
 	buf := NewLinkBuffer()
 	b1, _ := buf.Malloc(4)
@@ -443,6 +496,22 @@ func TestWriteDirect(t *testing.T) {
 	}
 }
 
+// TestWriteDirectAtNodeEnd inserts the data right behind all the malloc bytes of a node.
+func TestWriteDirectAtNodeEnd(t *testing.T) {
+	var buf = NewLinkBuffer()
+	bt, _ := buf.Malloc(4)
+	copy(bt, "head")
+	MustNil(t, buf.WriteDirect([]byte("body"), 0))
+	MustNil(t, buf.WriteDirect([]byte("tail"), 0))
+	bt, _ = buf.Malloc(1)
+	bt[0] = '!'
+	MustNil(t, buf.Flush())
+	Equal(t, buf.Len(), 13)
+	p, err := buf.Next(13)
+	MustNil(t, err)
+	Equal(t, string(p), "headbodytail!")
+}
+
 func BenchmarkLinkBufferConcurrentReadWrite(b *testing.B) {
 	b.StopTimer()
 
@@ -605,3 +674,253 @@ func BenchmarkCopyString(b *testing.B) {
 		}
 	})
 }
+
+func TestLinkBufferBinary(t *testing.T) {
+	var expect []byte
+	var tmp [binary.MaxVarintLen64]byte
+	expect = append(expect, 0x01, 0x02)
+	expect = append(expect, 0x01, 0x02, 0x03, 0x04)
+	expect = append(expect, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08)
+	expect = append(expect, 0x02, 0x01)
+	expect = append(expect, 0x04, 0x03, 0x02, 0x01)
+	expect = append(expect, 0x08, 0x07, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01)
+	expect = append(expect, tmp[:binary.PutUvarint(tmp[:], 300)]...)
+	expect = append(expect, tmp[:binary.PutVarint(tmp[:], -12345)]...)
+	expect = append(expect, tmp[:binary.PutUvarint(tmp[:], math.MaxUint64)]...)
+
+	// write
+	buf := NewLinkBuffer()
+	MustNil(t, buf.WriteUint16(0x0102))
+	MustNil(t, buf.WriteUint32(0x01020304))
+	MustNil(t, buf.WriteUint64(0x0102030405060708))
+	MustNil(t, buf.WriteUint16LE(0x0102))
+	MustNil(t, buf.WriteUint32LE(0x01020304))
+	MustNil(t, buf.WriteUint64LE(0x0102030405060708))
+	MustNil(t, buf.WriteUvarint(300))
+	MustNil(t, buf.WriteVarint(-12345))
+	MustNil(t, buf.WriteUvarint(math.MaxUint64))
+	MustNil(t, buf.Flush())
+	p, err := buf.Next(buf.Len())
+	MustNil(t, err)
+	Equal(t, string(p), string(expect))
+
+	// read across nodes at every position
+	for k := 0; k <= len(expect); k++ {
+		buf := NewLinkBuffer()
+		buf.WriteBinary(append(make([]byte, BinaryInplaceThreshold), expect[:k]...))
+		buf.WriteBinary(append([]byte{}, expect[k:]...))
+		MustNil(t, buf.Flush())
+		MustNil(t, buf.Skip(BinaryInplaceThreshold))
+
+		u16, err := buf.ReadUint16()
+		MustNil(t, err)
+		Equal(t, u16, uint16(0x0102))
+		u32, err := buf.ReadUint32()
+		MustNil(t, err)
+		Equal(t, u32, uint32(0x01020304))
+		u64, err := buf.ReadUint64()
+		MustNil(t, err)
+		Equal(t, u64, uint64(0x0102030405060708))
+		u16, err = buf.ReadUint16LE()
+		MustNil(t, err)
+		Equal(t, u16, uint16(0x0102))
+		u32, err = buf.ReadUint32LE()
+		MustNil(t, err)
+		Equal(t, u32, uint32(0x01020304))
+		u64, err = buf.ReadUint64LE()
+		MustNil(t, err)
+		Equal(t, u64, uint64(0x0102030405060708))
+		u64, err = buf.ReadUvarint()
+		MustNil(t, err)
+		Equal(t, u64, uint64(300))
+		i64, err := buf.ReadVarint()
+		MustNil(t, err)
+		Equal(t, i64, int64(-12345))
+		u64, err = buf.ReadUvarint()
+		MustNil(t, err)
+		Equal(t, u64, uint64(math.MaxUint64))
+		Equal(t, buf.Len(), 0)
+	}
+
+	// not enough
+	buf = NewLinkBuffer()
+	buf.WriteBinary([]byte{0x01, 0x80})
+	buf.Flush()
+	_, err = buf.ReadUint32()
+	MustTrue(t, err != nil)
+	Equal(t, buf.Len(), 2)
+	_, err = buf.ReadUint16()
+	MustNil(t, err)
+	buf.WriteBinary([]byte{0x80})
+	buf.Flush()
+	_, err = buf.ReadUvarint()
+	MustTrue(t, err != nil)
+	Equal(t, buf.Len(), 1)
+
+	// overflow
+	buf = NewLinkBuffer()
+	buf.WriteBinary(bytes.Repeat([]byte{0xff}, binary.MaxVarintLen64+1))
+	buf.Flush()
+	_, err = buf.ReadUvarint()
+	MustTrue(t, errors.Is(err, errVarintOverflow))
+}
+
+func TestLinkBufferIndexBytes(t *testing.T) {
+	var data = []byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n+OK\r\r\n")
+	var seps = []string{"\r\n", "\r\n\r\n", "\r\r\n", "HTTP", "+OK\r\r\n", "\n\n", "x"}
+	// split the data into 3 nodes at every position
+	for i := 0; i <= len(data); i++ {
+		for j := i; j <= len(data); j++ {
+			buf := NewLinkBuffer()
+			for _, chunk := range [][]byte{data[:i], data[i:j], data[j:]} {
+				node := NewLinkBuffer()
+				node.WriteBinary(append([]byte{}, chunk...))
+				node.Flush()
+				MustNil(t, buf.Append(node))
+			}
+			MustNil(t, buf.Flush())
+			Equal(t, buf.Len(), len(data))
+			for _, sep := range seps {
+				Equal(t, buf.IndexBytes([]byte(sep)), bytes.Index(data, []byte(sep)))
+				for skip := 0; skip < len(data); skip++ {
+					expect := bytes.Index(data[skip:], []byte(sep))
+					if expect >= 0 {
+						expect += skip
+					}
+					Equal(t, buf.indexBytes([]byte(sep), skip), expect)
+				}
+			}
+		}
+	}
+
+	buf := NewLinkBuffer()
+	buf.WriteBinary(data)
+	buf.Flush()
+	_, err := buf.UntilBytesLimit([]byte("\r\n\r\n"), 16)
+	MustTrue(t, errors.Is(err, ErrLineTooLong))
+	line, err := buf.UntilBytes([]byte("\r\n"))
+	MustNil(t, err)
+	Equal(t, string(line), "GET / HTTP/1.1\r\n")
+	line, err = buf.UntilBytesLimit([]byte("\r\n"), 9)
+	MustNil(t, err)
+	Equal(t, string(line), "Host: a\r\n")
+	_, err = buf.UntilBytes([]byte("\n\n"))
+	MustTrue(t, err != nil)
+}
+
+func TestLinkBufferNextVec(t *testing.T) {
+	var chunks = [][]byte{
+		bytes.Repeat([]byte("a"), 5000),
+		bytes.Repeat([]byte("b"), 6000),
+		bytes.Repeat([]byte("c"), 7000),
+	}
+	buf := NewLinkBuffer()
+	for _, chunk := range chunks {
+		node := NewLinkBuffer()
+		node.WriteBinary(chunk)
+		node.Flush()
+		MustNil(t, buf.Append(node))
+	}
+	MustNil(t, buf.Flush())
+
+	_, err := buf.PeekVec(18001)
+	MustTrue(t, err != nil)
+	vec, err := buf.PeekVec(12000)
+	MustNil(t, err)
+	Equal(t, len(vec), 3)
+	Equal(t, buf.Len(), 18000)
+
+	// skip the first 1000 bytes, and the next slices refer to the original chunks
+	vec, err = buf.NextVec(1000)
+	MustNil(t, err)
+	Equal(t, len(vec), 1)
+	vec, err = buf.NextVec(10000)
+	MustNil(t, err)
+	Equal(t, len(vec), 2)
+	Equal(t, len(vec[0]), 4000)
+	Equal(t, len(vec[1]), 6000)
+	MustTrue(t, &vec[0][0] == &chunks[0][1000])
+	MustTrue(t, &vec[1][0] == &chunks[1][0])
+	Equal(t, buf.Len(), 7000)
+
+	// forward the slices behind a header without copying
+	out := NewLinkBuffer()
+	header, _ := out.Malloc(4)
+	copy(header, "head")
+	for _, p := range vec {
+		MustNil(t, out.WriteDirect(p, 0))
+	}
+	MustNil(t, out.Flush())
+	Equal(t, out.Len(), 10004)
+	p, err := out.Next(10004)
+	MustNil(t, err)
+	MustTrue(t, bytes.Equal(p, append(append([]byte("head"), chunks[0][1000:]...), chunks[1]...)))
+
+	vec, err = buf.NextVec(7000)
+	MustNil(t, err)
+	Equal(t, len(vec), 1)
+	MustTrue(t, &vec[0][0] == &chunks[2][0])
+	Equal(t, buf.Len(), 0)
+	MustNil(t, buf.Release())
+}
+
+func TestLinkBufferTryNext(t *testing.T) {
+	var chunks = [][]byte{
+		bytes.Repeat([]byte("a"), 5000),
+		bytes.Repeat([]byte("b"), 6000),
+	}
+	buf := NewLinkBuffer()
+	for _, chunk := range chunks {
+		node := NewLinkBuffer()
+		node.WriteBinary(chunk)
+		node.Flush()
+		MustNil(t, buf.Append(node))
+	}
+	MustNil(t, buf.Flush())
+
+	_, err := buf.TryNext(11001)
+	MustTrue(t, errors.Is(err, ErrWouldBlock))
+	_, err = buf.TryPeek(11001)
+	MustTrue(t, errors.Is(err, ErrWouldBlock))
+	_, err = buf.PeekAtLeast(11001)
+	MustTrue(t, err != nil)
+	Equal(t, buf.Len(), 11000)
+
+	// the contiguous data of the first node is returned without copying
+	p, err := buf.PeekAtLeast(100)
+	MustNil(t, err)
+	Equal(t, len(p), 5000)
+	MustTrue(t, &p[0] == &chunks[0][0])
+	p, err = buf.TryNext(4000)
+	MustNil(t, err)
+	Equal(t, len(p), 4000)
+	p, err = buf.PeekAtLeast(0)
+	MustNil(t, err)
+	Equal(t, len(p), 1000)
+
+	// the next min bytes are copied across nodes
+	p, err = buf.PeekAtLeast(2000)
+	MustNil(t, err)
+	Equal(t, string(p), string(bytes.Repeat([]byte("a"), 1000))+string(bytes.Repeat([]byte("b"), 1000)))
+	p, err = buf.TryPeek(7000)
+	MustNil(t, err)
+	Equal(t, len(p), 7000)
+	Equal(t, buf.Len(), 7000)
+}
+
+func TestLinkBufferWriteToReadFrom(t *testing.T) {
+	var data = bytes.Repeat([]byte("0123456789"), 1000)
+	buf := NewLinkBuffer()
+	n, err := buf.ReadFrom(bytes.NewReader(data))
+	MustNil(t, err)
+	Equal(t, int(n), len(data))
+	Equal(t, buf.Len(), len(data))
+
+	// the data is written to io.Writer
+	var w bytes.Buffer
+	n, err = buf.WriteTo(&w)
+	MustNil(t, err)
+	Equal(t, int(n), len(data))
+	Equal(t, buf.Len(), 0)
+	MustTrue(t, bytes.Equal(w.Bytes(), data))
+}
@@ -0,0 +1,139 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"sync/atomic"
+)
+
+// MemoryStats is the statistics of the memory allocated by LinkBuffer, which is mostly used by connections.
+type MemoryStats struct {
+	Allocated uint64 // total bytes allocated
+	InUse     int64  // the bytes allocated and not released yet
+	Nodes     int64  // the number of the buffer nodes in use
+	Pooled    int64  // the number of the buffer nodes cached in the pool for reuse
+	Limit     int64  // the budget set by SetMemoryLimit, 0 means unlimited
+}
+
+// MemoryPolicy is the action taken when the memory in use exceeds the budget set by SetMemoryLimit.
+type MemoryPolicy int
+
+const (
+	// MemoryPolicyNone takes no action except calling MemoryLimit.OnExceeded.
+	MemoryPolicyNone MemoryPolicy = iota
+	// MemoryPolicyPauseRead stops reading the connections until the memory in use drops below the budget,
+	// except the connections waiting for more data, which are blocked in Next, Peek, etc.
+	MemoryPolicyPauseRead
+	// MemoryPolicyRejectConn closes the connections accepted by servers until the memory in use drops below the budget.
+	MemoryPolicyRejectConn
+	// MemoryPolicyCloseLargest closes the connections with the most buffered data until the budget is satisfied,
+	// and the closing reason is CloseReasonMemoryLimit.
+	MemoryPolicyCloseLargest
+)
+
+// MemoryLimit is the global memory budget of LinkBuffer.
+type MemoryLimit struct {
+	// Limit is the maximum bytes in use, 0 means unlimited.
+	Limit int64
+	// Policy is the action taken when the limit is exceeded.
+	Policy MemoryPolicy
+	// OnExceeded is called asynchronously once the limit is exceeded, and will not be called again
+	// until the memory in use drops below the limit.
+	OnExceeded func(stats MemoryStats)
+}
+
+var memStats struct {
+	allocated uint64
+	inuse     int64
+	nodes     int64
+	pooled    int64
+	limit     int64
+	exceeded  int32 // 1 after the limit is exceeded and until the memory drops below it
+}
+
+// memNotify is called when the memory in use exceeds the limit or drops below it.
+var memNotify func(exceeded bool)
+
+// ReadMemoryStats returns the statistics of the memory allocated by LinkBuffer.
+func ReadMemoryStats() (stats MemoryStats) {
+	return MemoryStats{
+		Allocated: atomic.LoadUint64(&memStats.allocated),
+		InUse:     atomic.LoadInt64(&memStats.inuse),
+		Nodes:     atomic.LoadInt64(&memStats.nodes),
+		Pooled:    atomic.LoadInt64(&memStats.pooled),
+		Limit:     atomic.LoadInt64(&memStats.limit),
+	}
+}
+
+func memAlloc(n int) {
+	atomic.AddUint64(&memStats.allocated, uint64(n))
+	atomic.AddInt64(&memStats.inuse, int64(n))
+	memCheck()
+}
+
+func memFree(n int) {
+	atomic.AddInt64(&memStats.inuse, -int64(n))
+	memCheck()
+}
+
+// memCheck updates memStats.exceeded and notifies the change. It checks again after updating,
+// since the memory may be allocated or freed concurrently between loading and updating.
+func memCheck() {
+	for {
+		limit := atomic.LoadInt64(&memStats.limit)
+		exceeded := atomic.LoadInt32(&memStats.exceeded) == 1
+		if limit <= 0 && !exceeded {
+			return
+		}
+		over := limit > 0 && atomic.LoadInt64(&memStats.inuse) > limit
+		if over == exceeded {
+			return
+		}
+		if over && atomic.CompareAndSwapInt32(&memStats.exceeded, 0, 1) ||
+			!over && atomic.CompareAndSwapInt32(&memStats.exceeded, 1, 0) {
+			if memNotify != nil {
+				memNotify(over)
+			}
+		}
+	}
+}
+
+// memExceeded reports whether the memory in use exceeds the limit.
+func memExceeded() bool {
+	return atomic.LoadInt32(&memStats.exceeded) == 1
+}
+
+// forget removes the memory of the LinkBuffer from the accounting when it is dropped without Close,
+// and the memory is not recycled since the slices read from it may be still in use.
+func (b *LinkBuffer) forget() {
+	for node := b.head; node != nil; node = node.next {
+		node.forget()
+	}
+	for i := range b.caches {
+		memFree(cap(b.caches[i]))
+	}
+}
+
+func (node *linkBufferNode) forget() {
+	if node.origin != nil {
+		node.origin.forget()
+	}
+	if atomic.AddInt32(&node.refer, -1) == 0 {
+		if !node.readonly {
+			memFree(cap(node.buf))
+		}
+		atomic.AddInt64(&memStats.nodes, -1)
+	}
+}
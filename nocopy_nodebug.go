@@ -0,0 +1,35 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !netpoll_debug
+// +build !netpoll_debug
+
+package netpoll
+
+// debugMode is disabled without the build tag netpoll_debug, see nocopy_debug.go.
+const debugMode = false
+
+func debugTrack(node *linkBufferNode, p []byte) {}
+
+func debugFree(buf []byte) {}
+
+func debugCheck(p []byte) {}
+
+func debugDoubleRelease() {}
+
+func debugMalloc(size, capacity int) []byte { return nil }
+
+func debugPanicOnFault() bool { return false }
+
+func debugRecoverFault(old bool) {}
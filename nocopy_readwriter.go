@@ -52,6 +52,42 @@ func (r *zcReader) Peek(n int) (buf []byte, err error) {
 	return r.buf.Peek(n)
 }
 
+// NextVec implements Reader.
+func (r *zcReader) NextVec(n int) (p [][]byte, err error) {
+	if err = r.waitRead(n); err != nil {
+		return p, err
+	}
+	return r.buf.NextVec(n)
+}
+
+// PeekVec implements Reader.
+func (r *zcReader) PeekVec(n int) (p [][]byte, err error) {
+	if err = r.waitRead(n); err != nil {
+		return p, err
+	}
+	return r.buf.PeekVec(n)
+}
+
+// TryNext implements Reader.
+// The wrapped io.Reader cannot be read without blocking, so it blocks like Next if there are fewer than n bytes,
+// and returns ErrEOF instead of ErrWouldBlock if no more data will arrive.
+func (r *zcReader) TryNext(n int) (p []byte, err error) {
+	return r.Next(n)
+}
+
+// TryPeek implements Reader, which blocks like Peek for the same reason as TryNext.
+func (r *zcReader) TryPeek(n int) (buf []byte, err error) {
+	return r.Peek(n)
+}
+
+// PeekAtLeast implements Reader.
+func (r *zcReader) PeekAtLeast(min int) (buf []byte, err error) {
+	if err = r.waitRead(min); err != nil {
+		return buf, err
+	}
+	return r.buf.PeekAtLeast(min)
+}
+
 // Skip implements Reader.
 func (r *zcReader) Skip(n int) (err error) {
 	if err = r.waitRead(n); err != nil {
@@ -102,10 +138,126 @@ func (r *zcReader) ReadByte() (b byte, err error) {
 	return r.buf.ReadByte()
 }
 
+// ReadUint16 implements Reader.
+func (r *zcReader) ReadUint16() (v uint16, err error) {
+	if err = r.waitRead(2); err != nil {
+		return v, err
+	}
+	return r.buf.ReadUint16()
+}
+
+// ReadUint32 implements Reader.
+func (r *zcReader) ReadUint32() (v uint32, err error) {
+	if err = r.waitRead(4); err != nil {
+		return v, err
+	}
+	return r.buf.ReadUint32()
+}
+
+// ReadUint64 implements Reader.
+func (r *zcReader) ReadUint64() (v uint64, err error) {
+	if err = r.waitRead(8); err != nil {
+		return v, err
+	}
+	return r.buf.ReadUint64()
+}
+
+// ReadUint16LE implements Reader.
+func (r *zcReader) ReadUint16LE() (v uint16, err error) {
+	if err = r.waitRead(2); err != nil {
+		return v, err
+	}
+	return r.buf.ReadUint16LE()
+}
+
+// ReadUint32LE implements Reader.
+func (r *zcReader) ReadUint32LE() (v uint32, err error) {
+	if err = r.waitRead(4); err != nil {
+		return v, err
+	}
+	return r.buf.ReadUint32LE()
+}
+
+// ReadUint64LE implements Reader.
+func (r *zcReader) ReadUint64LE() (v uint64, err error) {
+	if err = r.waitRead(8); err != nil {
+		return v, err
+	}
+	return r.buf.ReadUint64LE()
+}
+
+// ReadUvarint implements Reader.
+func (r *zcReader) ReadUvarint() (v uint64, err error) {
+	if err = r.waitUvarint(); err != nil {
+		return v, err
+	}
+	return r.buf.ReadUvarint()
+}
+
+// ReadVarint implements Reader.
+func (r *zcReader) ReadVarint() (v int64, err error) {
+	if err = r.waitUvarint(); err != nil {
+		return v, err
+	}
+	return r.buf.ReadVarint()
+}
+
+// waitUvarint waits until the varint is complete or overflows.
+func (r *zcReader) waitUvarint() (err error) {
+	for n := 1; ; n = r.buf.Len() + 1 {
+		if err = r.waitRead(n); err != nil {
+			return err
+		}
+		if r.buf.uvarintLen() != 0 {
+			return nil
+		}
+	}
+}
+
 func (r *zcReader) Until(delim byte) (line []byte, err error) {
 	return r.buf.Until(delim)
 }
 
+// UntilBytes implements Reader.
+func (r *zcReader) UntilBytes(delim []byte) (line []byte, err error) {
+	return untilBytes(r.buf, r.waitRead, delim, 0)
+}
+
+// UntilBytesLimit implements Reader.
+func (r *zcReader) UntilBytesLimit(delim []byte, limit int) (line []byte, err error) {
+	return untilBytes(r.buf, r.waitRead, delim, limit)
+}
+
+// IndexBytes implements Reader.
+func (r *zcReader) IndexBytes(sep []byte) (index int) {
+	return r.buf.IndexBytes(sep)
+}
+
+// untilBytes waits until delim is found in buf, and then reads the line ends with delim.
+// The line is at most limit bytes if limit > 0.
+func untilBytes(buf *LinkBuffer, waitRead func(n int) error, delim []byte, limit int) (line []byte, err error) {
+	var n int // the searched bytes
+	for {
+		if err = waitRead(n + len(delim)); err != nil {
+			// return all the data in the buffer
+			line, _ = buf.Next(buf.Len())
+			return line, err
+		}
+		l := buf.Len()
+		i := buf.indexBytes(delim, n)
+		if i >= 0 {
+			if limit > 0 && i+len(delim) > limit {
+				return nil, Exception(ErrLineTooLong, fmt.Sprintf("limit[%d]", limit))
+			}
+			return buf.Next(i + len(delim))
+		}
+		if limit > 0 && l >= limit {
+			return nil, Exception(ErrLineTooLong, fmt.Sprintf("limit[%d]", limit))
+		}
+		n = l - len(delim) + 1 // the delim may begin at the tail
+	}
+}
+
 func (r *zcReader) waitRead(n int) (err error) {
 	for r.buf.Len() < n {
 		err = r.fill(n)
@@ -210,6 +362,46 @@ func (w *zcWriter) WriteByte(b byte) (err error) {
 	return w.buf.WriteByte(b)
 }
 
+// WriteUint16 implements Writer.
+func (w *zcWriter) WriteUint16(v uint16) (err error) {
+	return w.buf.WriteUint16(v)
+}
+
+// WriteUint32 implements Writer.
+func (w *zcWriter) WriteUint32(v uint32) (err error) {
+	return w.buf.WriteUint32(v)
+}
+
+// WriteUint64 implements Writer.
+func (w *zcWriter) WriteUint64(v uint64) (err error) {
+	return w.buf.WriteUint64(v)
+}
+
+// WriteUint16LE implements Writer.
+func (w *zcWriter) WriteUint16LE(v uint16) (err error) {
+	return w.buf.WriteUint16LE(v)
+}
+
+// WriteUint32LE implements Writer.
+func (w *zcWriter) WriteUint32LE(v uint32) (err error) {
+	return w.buf.WriteUint32LE(v)
+}
+
+// WriteUint64LE implements Writer.
+func (w *zcWriter) WriteUint64LE(v uint64) (err error) {
+	return w.buf.WriteUint64LE(v)
+}
+
+// WriteUvarint implements Writer.
+func (w *zcWriter) WriteUvarint(v uint64) (err error) {
+	return w.buf.WriteUvarint(v)
+}
+
+// WriteVarint implements Writer.
+func (w *zcWriter) WriteVarint(v int64) (err error) {
+	return w.buf.WriteVarint(v)
+}
+
 // zcWriter implements ReadWriter.
 type zcReadWriter struct {
 	*zcReader
@@ -223,6 +415,7 @@ func newIOReader(r Reader) *ioReader {
 }
 
 var _ io.Reader = &ioReader{}
+var _ io.WriterTo = &ioReader{}
 
 // ioReader implements io.Reader.
 type ioReader struct {
@@ -254,6 +447,12 @@ func (r *ioReader) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
+// WriteTo implements io.WriterTo, which writes the buffered data to w without waiting for more like Read,
+// so it is not delegated to WriteTo of connections, which waits until EOF.
+func (r *ioReader) WriteTo(w io.Writer) (n int64, err error) {
+	return writeTo(r.r, w)
+}
+
 func newIOWriter(w Writer) *ioWriter {
 	return &ioWriter{
 		w: w,
@@ -261,6 +460,7 @@ func newIOWriter(w Writer) *ioWriter {
 }
 
 var _ io.Writer = &ioWriter{}
+var _ io.ReaderFrom = &ioWriter{}
 
 // ioWriter implements io.Writer.
 type ioWriter struct {
@@ -281,6 +481,14 @@ func (w *ioWriter) Write(p []byte) (n int, err error) {
 	return n, nil
 }
 
+// ReadFrom implements io.ReaderFrom.
+func (w *ioWriter) ReadFrom(r io.Reader) (n int64, err error) {
+	if rf, ok := w.w.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return readFrom(w.w, r)
+}
+
 // ioReadWriter implements io.ReadWriter.
 type ioReadWriter struct {
 	*ioReader
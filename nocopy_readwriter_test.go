@@ -46,6 +46,11 @@ func TestZCReader(t *testing.T) {
 	MustNil(t, err)
 	Equal(t, r.buf.Len(), 0)
 
+	// TryNext reads the wrapped reader if the buffer is not enough
+	p, err = r.TryNext(block1k)
+	MustNil(t, err)
+	Equal(t, len(p), block1k)
+
 	err = r.Release()
 	MustNil(t, err)
 }
@@ -91,6 +96,11 @@ func TestZCEOF(t *testing.T) {
 
 	_, err := r.Next(block8k)
 	MustTrue(t, errors.Is(err, ErrEOF))
+	// TryNext and TryPeek read the wrapped reader too, and report EOF instead of ErrWouldBlock.
+	_, err = r.TryNext(1)
+	MustTrue(t, errors.Is(err, ErrEOF))
+	_, err = newZCReader(reader).TryPeek(1)
+	MustTrue(t, errors.Is(err, ErrEOF))
 }
 
 type MockIOReadWriter struct {
@@ -138,3 +148,18 @@ func TestIOReadWriter2(t *testing.T) {
 	MustNil(t, err)
 	Equal(t, len(p), len(msg))
 }
+
+func TestIOCopy(t *testing.T) {
+	src, dst := NewLinkBuffer(block1k), NewLinkBuffer(block1k)
+	msg := []byte("hello world")
+	src.WriteBinary(msg)
+	src.Flush()
+
+	n, err := io.Copy(NewIOWriter(dst), NewIOReader(src))
+	MustNil(t, err)
+	Equal(t, int(n), len(msg))
+	Equal(t, src.Len(), 0)
+	p, err := dst.Next(len(msg))
+	MustNil(t, err)
+	Equal(t, string(p), string(msg))
+}
@@ -61,4 +61,22 @@ const (
 
 	// PollRW2R is used to remove the writable monitor of FDOperator, generally used with PollR2RW.
 	PollRW2R PollEvent = 0x6
+
+	// PollShutRead is used to stop monitoring readable for FDOperator after its input side has been shut down.
+	// It monitors writable once to flush the remaining output, which can be removed by PollRW2R,
+	// and the later PollR2RW and PollRW2R will not monitor readable anymore.
+	PollShutRead PollEvent = 0x7
+
+	// pollPauseRead stops the poll reading the FDOperator, which is read by the connection directly, e.g. splice.
+	// pollNotifyRead monitors readable again, but the poll only notifies the connection by Inputs instead of reading.
+	// pollResumeRead makes the poll read the FDOperator as usual.
+	// All of them keep monitoring writable if PollR2RW is in effect.
+	pollPauseRead  PollEvent = 0x8
+	pollNotifyRead PollEvent = 0x9
+	pollResumeRead PollEvent = 0xa
+
+	// pollHoldRead stops the poll reading the FDOperator when the memory limit is exceeded,
+	// which is independent of pollPauseRead, and pollUnholdRead removes it.
+	pollHoldRead   PollEvent = 0xb
+	pollUnholdRead PollEvent = 0xc
 )
@@ -20,6 +20,7 @@ package netpoll
 
 import (
 	"log"
+	"os"
 	"sync/atomic"
 	"syscall"
 	"unsafe"
@@ -85,6 +86,7 @@ func (p *defaultPoll) Wait() error {
 			}
 
 			// check poll in
+			var eof bool
 			if events[i].Filter == syscall.EVFILT_READ && events[i].Flags&syscall.EV_ENABLE != 0 {
 				if operator.OnRead != nil {
 					// for non-connection
@@ -93,21 +95,30 @@ func (p *defaultPoll) Wait() error {
 					// only for connection
 					var bs = operator.Inputs(barriers[i].bs)
 					if len(bs) > 0 {
-						var n, err = readv(operator.FD, bs, barriers[i].ivs)
+						var n, err = operator.readv(bs, barriers[i].ivs)
 						operator.InputAck(n)
 						if err != nil && err != syscall.EAGAIN && err != syscall.EINTR {
 							log.Printf("readv(fd=%d) failed: %s", operator.FD, err.Error())
+							operator.setHupErr(os.NewSyscallError("readv", err))
 							p.appendHup(operator)
 							continue
 						}
+						eof = n == 0 && err == nil
 					}
 				}
 			}
 
 			// check hup
-			if events[i].Flags&syscall.EV_EOF != 0 {
-				p.appendHup(operator)
-				continue
+			if events[i].Flags&syscall.EV_EOF != 0 && (events[i].Filter != syscall.EVFILT_READ || !operator.isReadShut()) {
+				if events[i].Filter != syscall.EVFILT_READ || operator.OnRdHup == nil {
+					p.appendHup(operator)
+					continue
+				}
+				// half-closed by peer, stop reading only after all the input data has been read.
+				if eof {
+					operator.Control(PollShutRead)
+					operator.OnRdHup(p)
+				}
 			}
 
 			// check poll out
@@ -124,6 +135,7 @@ func (p *defaultPoll) Wait() error {
 						operator.OutputAck(n)
 						if err != nil && err != syscall.EAGAIN {
 							log.Printf("sendmsg(fd=%d) failed: %s", operator.FD, err.Error())
+							operator.setHupErr(os.NewSyscallError("sendmsg", err))
 							p.appendHup(operator)
 							continue
 						}
@@ -164,6 +176,9 @@ func (p *defaultPoll) Control(operator *FDOperator, event PollEvent) error {
 	switch event {
 	case PollReadable, PollModReadable:
 		operator.inuse()
+		if operator.isReadShut() {
+			return nil
+		}
 		evs[0].Filter, evs[0].Flags = syscall.EVFILT_READ, syscall.EV_ADD|syscall.EV_ENABLE
 	case PollDetach:
 		evs[0].Filter, evs[0].Flags = syscall.EVFILT_READ, syscall.EV_DELETE|syscall.EV_ONESHOT
@@ -174,6 +189,15 @@ func (p *defaultPoll) Control(operator *FDOperator, event PollEvent) error {
 		evs[0].Filter, evs[0].Flags = syscall.EVFILT_WRITE, syscall.EV_ADD|syscall.EV_ENABLE
 	case PollRW2R:
 		evs[0].Filter, evs[0].Flags = syscall.EVFILT_WRITE, syscall.EV_DELETE|syscall.EV_ONESHOT
+	case PollShutRead:
+		operator.shutRead()
+		evs[0].Filter, evs[0].Flags = syscall.EVFILT_READ, syscall.EV_DELETE|syscall.EV_ONESHOT
+	case pollPauseRead, pollNotifyRead, pollResumeRead, pollHoldRead, pollUnholdRead:
+		operator.setPause(event)
+		evs[0].Filter, evs[0].Flags = syscall.EVFILT_READ, syscall.EV_DISABLE
+		if readable, _ := operator.monitorRead(); readable {
+			evs[0].Flags = syscall.EV_ENABLE
+		}
 	}
 	_, err := syscall.Kevent(p.fd, evs, nil, nil)
 	return err
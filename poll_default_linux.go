@@ -19,6 +19,7 @@ package netpoll
 
 import (
 	"log"
+	"os"
 	"runtime"
 	"sync/atomic"
 	"syscall"
@@ -130,6 +131,7 @@ func (p *defaultPoll) handler(events []epollevent) (closed bool) {
 
 		evt := events[i].events
 		// check poll in
+		var eof bool
 		if evt&syscall.EPOLLIN != 0 {
 			if operator.OnRead != nil {
 				// for non-connection
@@ -138,22 +140,35 @@ func (p *defaultPoll) handler(events []epollevent) (closed bool) {
 				// for connection
 				var bs = operator.Inputs(p.barriers[i].bs)
 				if len(bs) > 0 {
-					var n, err = readv(operator.FD, bs, p.barriers[i].ivs)
+					var n, err = operator.readv(bs, p.barriers[i].ivs)
 					operator.InputAck(n)
 					if err != nil && err != syscall.EAGAIN && err != syscall.EINTR {
 						log.Printf("readv(fd=%d) failed: %s", operator.FD, err.Error())
+						operator.setHupErr(os.NewSyscallError("readv", err))
 						p.appendHup(operator)
 						continue
 					}
+					eof = n == 0 && err == nil
 				}
 			}
 		}
 
 		// check hup
-		if evt&(syscall.EPOLLHUP|syscall.EPOLLRDHUP) != 0 {
+		if evt&syscall.EPOLLHUP != 0 {
 			p.appendHup(operator)
 			continue
 		}
+		if evt&syscall.EPOLLRDHUP != 0 && !operator.isReadShut() {
+			if operator.OnRdHup == nil {
+				p.appendHup(operator)
+				continue
+			}
+			// half-closed by peer, stop reading only after all the input data has been read.
+			if eof {
+				operator.Control(PollShutRead)
+				operator.OnRdHup(p)
+			}
+		}
 		if evt&syscall.EPOLLERR != 0 {
 			// Under block-zerocopy, the kernel may give an error callback, which is not a real error, just an EAGAIN.
 			// So here we need to check this error, if it is EAGAIN then do nothing, otherwise still mark as hup.
@@ -178,6 +193,7 @@ func (p *defaultPoll) handler(events []epollevent) (closed bool) {
 					operator.OutputAck(n)
 					if err != nil && err != syscall.EAGAIN {
 						log.Printf("sendmsg(fd=%d) failed: %s", operator.FD, err.Error())
+						operator.setHupErr(os.NewSyscallError("sendmsg", err))
 						p.appendHup(operator)
 						continue
 					}
@@ -212,12 +228,15 @@ func (p *defaultPoll) Control(operator *FDOperator, event PollEvent) error {
 	var op int
 	var evt epollevent
 	*(**FDOperator)(unsafe.Pointer(&evt.data)) = operator
+	operator.mu.Lock()
+	defer operator.mu.Unlock()
 	switch event {
 	case PollReadable:
 		operator.inuse()
 		op, evt.events = syscall.EPOLL_CTL_ADD, syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLERR
 	case PollModReadable:
 		operator.inuse()
+		operator.out = false
 		op, evt.events = syscall.EPOLL_CTL_MOD, syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLERR
 	case PollDetach:
 		op, evt.events = syscall.EPOLL_CTL_DEL, syscall.EPOLLIN|syscall.EPOLLOUT|syscall.EPOLLRDHUP|syscall.EPOLLERR
@@ -225,9 +244,26 @@ func (p *defaultPoll) Control(operator *FDOperator, event PollEvent) error {
 		operator.inuse()
 		op, evt.events = syscall.EPOLL_CTL_ADD, EPOLLET|syscall.EPOLLOUT|syscall.EPOLLRDHUP|syscall.EPOLLERR
 	case PollR2RW:
+		operator.out = true
 		op, evt.events = syscall.EPOLL_CTL_MOD, syscall.EPOLLIN|syscall.EPOLLOUT|syscall.EPOLLRDHUP|syscall.EPOLLERR
 	case PollRW2R:
+		operator.out = false
 		op, evt.events = syscall.EPOLL_CTL_MOD, syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLERR
+	case PollShutRead:
+		operator.shutRead()
+		operator.out = true
+		op, evt.events = syscall.EPOLL_CTL_MOD, syscall.EPOLLOUT|syscall.EPOLLERR
+	case pollPauseRead, pollNotifyRead, pollResumeRead, pollHoldRead, pollUnholdRead:
+		operator.setPause(event)
+		op, evt.events = syscall.EPOLL_CTL_MOD, syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLERR
+		if operator.out {
+			evt.events |= syscall.EPOLLOUT
+		}
+	}
+	if readable, rdhup := operator.monitorRead(); !readable {
+		evt.events &^= syscall.EPOLLIN | syscall.EPOLLRDHUP
+	} else if !rdhup {
+		evt.events &^= syscall.EPOLLRDHUP
 	}
 	return EpollCtl(p.fd, op, operator.FD, &evt)
 }
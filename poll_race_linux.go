@@ -19,6 +19,7 @@ package netpoll
 
 import (
 	"log"
+	"os"
 	"runtime"
 	"sync"
 	"sync/atomic"
@@ -127,6 +128,7 @@ func (p *defaultPoll) handler(events []syscall.EpollEvent) (closed bool) {
 
 		evt := events[i].Events
 		// check poll in
+		var eof bool
 		if evt&syscall.EPOLLIN != 0 {
 			if operator.OnRead != nil {
 				// for non-connection
@@ -135,22 +137,35 @@ func (p *defaultPoll) handler(events []syscall.EpollEvent) (closed bool) {
 				// for connection
 				var bs = operator.Inputs(p.barriers[i].bs)
 				if len(bs) > 0 {
-					var n, err = readv(operator.FD, bs, p.barriers[i].ivs)
+					var n, err = operator.readv(bs, p.barriers[i].ivs)
 					operator.InputAck(n)
 					if err != nil && err != syscall.EAGAIN && err != syscall.EINTR {
 						log.Printf("readv(fd=%d) failed: %s", operator.FD, err.Error())
+						operator.setHupErr(os.NewSyscallError("readv", err))
 						p.appendHup(operator)
 						continue
 					}
+					eof = n == 0 && err == nil
 				}
 			}
 		}
 
 		// check hup
-		if evt&(syscall.EPOLLHUP|syscall.EPOLLRDHUP) != 0 {
+		if evt&syscall.EPOLLHUP != 0 {
 			p.appendHup(operator)
 			continue
 		}
+		if evt&syscall.EPOLLRDHUP != 0 && !operator.isReadShut() {
+			if operator.OnRdHup == nil {
+				p.appendHup(operator)
+				continue
+			}
+			// half-closed by peer, stop reading only after all the input data has been read.
+			if eof {
+				operator.Control(PollShutRead)
+				operator.OnRdHup(p)
+			}
+		}
 		if evt&syscall.EPOLLERR != 0 {
 			// Under block-zerocopy, the kernel may give an error callback, which is not a real error, just an EAGAIN.
 			// So here we need to check this error, if it is EAGAIN then do nothing, otherwise still mark as hup.
@@ -176,6 +191,7 @@ func (p *defaultPoll) handler(events []syscall.EpollEvent) (closed bool) {
 					operator.OutputAck(n)
 					if err != nil && err != syscall.EAGAIN {
 						log.Printf("sendmsg(fd=%d) failed: %s", operator.FD, err.Error())
+						operator.setHupErr(os.NewSyscallError("sendmsg", err))
 						p.appendHup(operator)
 						continue
 					}
@@ -218,6 +234,8 @@ func (p *defaultPoll) Control(operator *FDOperator, event PollEvent) error {
 	var op int
 	var evt syscall.EpollEvent
 	evt.Fd = int32(operator.FD)
+	operator.mu.Lock()
+	defer operator.mu.Unlock()
 	switch event {
 	case PollReadable:
 		operator.inuse()
@@ -225,6 +243,7 @@ func (p *defaultPoll) Control(operator *FDOperator, event PollEvent) error {
 		op, evt.Events = syscall.EPOLL_CTL_ADD, syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLERR
 	case PollModReadable:
 		operator.inuse()
+		operator.out = false
 		p.m.Store(operator.FD, operator)
 		op, evt.Events = syscall.EPOLL_CTL_MOD, syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLERR
 	case PollDetach:
@@ -235,9 +254,26 @@ func (p *defaultPoll) Control(operator *FDOperator, event PollEvent) error {
 		p.m.Store(operator.FD, operator)
 		op, evt.Events = syscall.EPOLL_CTL_ADD, EPOLLET|syscall.EPOLLOUT|syscall.EPOLLRDHUP|syscall.EPOLLERR
 	case PollR2RW:
+		operator.out = true
 		op, evt.Events = syscall.EPOLL_CTL_MOD, syscall.EPOLLIN|syscall.EPOLLOUT|syscall.EPOLLRDHUP|syscall.EPOLLERR
 	case PollRW2R:
+		operator.out = false
 		op, evt.Events = syscall.EPOLL_CTL_MOD, syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLERR
+	case PollShutRead:
+		operator.shutRead()
+		operator.out = true
+		op, evt.Events = syscall.EPOLL_CTL_MOD, syscall.EPOLLOUT|syscall.EPOLLERR
+	case pollPauseRead, pollNotifyRead, pollResumeRead, pollHoldRead, pollUnholdRead:
+		operator.setPause(event)
+		op, evt.Events = syscall.EPOLL_CTL_MOD, syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLERR
+		if operator.out {
+			evt.Events |= syscall.EPOLLOUT
+		}
+	}
+	if readable, rdhup := operator.monitorRead(); !readable {
+		evt.Events &^= syscall.EPOLLIN | syscall.EPOLLRDHUP
+	} else if !rdhup {
+		evt.Events &^= syscall.EPOLLRDHUP
 	}
 	return syscall.EpollCtl(p.fd, op, operator.FD, &evt)
 }
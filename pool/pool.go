@@ -0,0 +1,391 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+// Package pool provides a client connection pool of netpoll.Connection.
+package pool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+var (
+	// ErrPoolClosed is returned by Get after the pool is closed.
+	ErrPoolClosed = errors.New("connection pool closed")
+	// ErrNotPooled is returned by Put if the connection is not got from the pool.
+	ErrNotPooled = errors.New("connection not got from the pool")
+	// ErrAlreadyIdle is returned by Put if the connection has been put back and not got again.
+	ErrAlreadyIdle = errors.New("connection already idle in the pool")
+)
+
+// Config is the configuration of ConnPool.
+type Config struct {
+	// Dialer dials the new connections, netpoll.NewDialer() is used if nil.
+	Dialer netpoll.Dialer
+
+	// Network is the network of the addresses, default is "tcp".
+	Network string
+
+	// DialTimeout is the timeout of dialing a new connection, 0 means only limited by the ctx of Get.
+	DialTimeout time.Duration
+
+	// MaxIdle is the maximum number of idle connections per address, default is 2.
+	MaxIdle int
+
+	// MaxActive is the maximum number of connections per address, including the idle ones.
+	// Get waits for an available connection until the ctx is done when exceeded. 0 means unlimited.
+	MaxActive int
+
+	// IdleTimeout closes the connections which have been idle for longer than it, 0 means never.
+	IdleTimeout time.Duration
+
+	// HealthCheck checks an idle connection before returned by Get, and the connection is closed if failed.
+	// The connections which are inactive or have unread data are always discarded.
+	HealthCheck func(conn netpoll.Connection) error
+}
+
+const defaultMaxIdle = 2
+
+// ConnPool is a connection pool with a sub-pool for each address, which is safe for concurrent use.
+// The connections got by Get should be returned by Put or closed after used.
+type ConnPool struct {
+	config Config
+	mu     sync.Mutex
+	pools  map[string]*addrPool
+	closed bool
+	stop   chan struct{}
+}
+
+// NewConnPool creates a ConnPool.
+func NewConnPool(config Config) *ConnPool {
+	if config.Dialer == nil {
+		config.Dialer = netpoll.NewDialer()
+	}
+	if config.Network == "" {
+		config.Network = "tcp"
+	}
+	if config.MaxIdle <= 0 {
+		config.MaxIdle = defaultMaxIdle
+	}
+	p := &ConnPool{
+		config: config,
+		pools:  make(map[string]*addrPool),
+		stop:   make(chan struct{}),
+	}
+	if config.IdleTimeout > 0 {
+		go p.evictLoop()
+	}
+	return p
+}
+
+// Get returns an active connection to addr, which reuses an idle connection first and dials a new one otherwise.
+func (p *ConnPool) Get(ctx context.Context, addr string) (netpoll.Connection, error) {
+	ap, err := p.addrPool(addr)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if conn := ap.pop(); conn != nil {
+			if p.check(conn) {
+				return conn, nil
+			}
+			continue
+		}
+		if ap.slots == nil {
+			return p.dial(ctx, ap)
+		}
+		select {
+		case ap.slots <- struct{}{}:
+			return p.dial(ctx, ap)
+		case <-ap.notify: // an idle connection is put back
+		case <-p.stop:
+			return nil, ErrPoolClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Put returns the connection to the pool. The connection is closed
+// if it is inactive, the pool is full or closed. Putting an idle connection again is rejected by ErrAlreadyIdle.
+func (p *ConnPool) Put(conn netpoll.Connection) error {
+	pc, ok := getPooled(conn)
+	if !ok {
+		return ErrNotPooled
+	}
+	if !conn.IsActive() || conn.Reader().Len() > 0 {
+		return conn.Close()
+	}
+	if pushed, err := pc.pool.push(conn, pc, p.config.MaxIdle); err != nil {
+		return err
+	} else if !pushed {
+		return conn.Close()
+	}
+	return nil
+}
+
+// Len returns the number of the connections to addr, and the number of idle ones.
+func (p *ConnPool) Len(addr string) (active, idle int) {
+	p.mu.Lock()
+	ap := p.pools[addr]
+	p.mu.Unlock()
+	if ap == nil {
+		return 0, 0
+	}
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	return int(atomic.LoadInt32(&ap.active)), len(ap.idle)
+}
+
+// Close closes all the idle connections, and the connections put later.
+func (p *ConnPool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.stop)
+	pools := p.pools
+	p.mu.Unlock()
+
+	for _, ap := range pools {
+		for _, conn := range ap.close() {
+			conn.Close()
+		}
+	}
+	return nil
+}
+
+func (p *ConnPool) addrPool(addr string) (*addrPool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrPoolClosed
+	}
+	ap, ok := p.pools[addr]
+	if !ok {
+		ap = &addrPool{addr: addr, notify: make(chan struct{}, 1)}
+		if p.config.MaxActive > 0 {
+			ap.slots = make(chan struct{}, p.config.MaxActive)
+		}
+		p.pools[addr] = ap
+	}
+	return ap, nil
+}
+
+// dial dials a new connection, and the slot has been acquired if MaxActive is set.
+func (p *ConnPool) dial(ctx context.Context, ap *addrPool) (netpoll.Connection, error) {
+	if p.config.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.config.DialTimeout)
+		defer cancel()
+	}
+	atomic.AddInt32(&ap.active, 1)
+	pc := &pooledConn{pool: ap}
+	conn, err := p.config.Dialer.DialContext(ctx, p.config.Network, ap.addr)
+	if err != nil {
+		pc.release()
+		return nil, err
+	}
+	conn.Set(pooledKey{}, pc)
+	conn.AddCloseCallback(func(netpoll.Connection) error {
+		ap.remove(conn)
+		pc.release()
+		return nil
+	})
+	// the connection may be closed before adding the callback.
+	if !conn.IsActive() {
+		pc.release()
+	}
+	return conn, nil
+}
+
+// check reports whether the idle connection can be reused, and closes it if not.
+// Note that the connection closed by the peer must also be closed to release the resources.
+func (p *ConnPool) check(conn netpoll.Connection) bool {
+	if !conn.IsActive() || conn.Reader().Len() > 0 {
+		conn.Close()
+		return false
+	}
+	if p.config.HealthCheck != nil && p.config.HealthCheck(conn) != nil {
+		conn.Close()
+		return false
+	}
+	return true
+}
+
+func (p *ConnPool) evictLoop() {
+	ticker := time.NewTicker(p.config.IdleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case now := <-ticker.C:
+			p.mu.Lock()
+			pools := make([]*addrPool, 0, len(p.pools))
+			for _, ap := range p.pools {
+				pools = append(pools, ap)
+			}
+			p.mu.Unlock()
+			for _, ap := range pools {
+				for _, conn := range ap.evict(now.Add(-p.config.IdleTimeout)) {
+					conn.Close()
+				}
+			}
+		}
+	}
+}
+
+type pooledKey struct{}
+
+// pooledConn is attached to the connection got from the pool.
+type pooledConn struct {
+	pool     *addrPool
+	released int32
+	idle     bool // whether the connection is in the idle list, guarded by pool.mu
+}
+
+func getPooled(conn netpoll.Connection) (*pooledConn, bool) {
+	if conn == nil {
+		return nil, false
+	}
+	v, ok := conn.Get(pooledKey{})
+	if !ok {
+		return nil, false
+	}
+	pc, ok := v.(*pooledConn)
+	return pc, ok
+}
+
+// release releases the slot of the connection only once.
+func (pc *pooledConn) release() {
+	if !atomic.CompareAndSwapInt32(&pc.released, 0, 1) {
+		return
+	}
+	atomic.AddInt32(&pc.pool.active, -1)
+	if pc.pool.slots != nil {
+		<-pc.pool.slots
+	}
+}
+
+type idleConn struct {
+	conn  netpoll.Connection
+	pc    *pooledConn
+	since time.Time
+}
+
+// addrPool is the sub-pool of an address.
+type addrPool struct {
+	addr   string
+	active int32
+	slots  chan struct{} // nil if MaxActive is unlimited
+	notify chan struct{}
+	mu     sync.Mutex
+	idle   []idleConn // the oldest is first
+	closed bool
+}
+
+// pop returns the latest idle connection.
+func (ap *addrPool) pop() netpoll.Connection {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	if len(ap.idle) == 0 {
+		return nil
+	}
+	n := len(ap.idle) - 1
+	conn := ap.idle[n].conn
+	ap.idle[n].pc.idle = false
+	ap.idle[n] = idleConn{}
+	ap.idle = ap.idle[:n]
+	return conn
+}
+
+// push appends the connection to the idle list, and returns false if the pool is full or closed.
+func (ap *addrPool) push(conn netpoll.Connection, pc *pooledConn, maxIdle int) (bool, error) {
+	ap.mu.Lock()
+	if pc.idle {
+		ap.mu.Unlock()
+		return false, ErrAlreadyIdle
+	}
+	if ap.closed || len(ap.idle) >= maxIdle {
+		ap.mu.Unlock()
+		return false, nil
+	}
+	pc.idle = true
+	ap.idle = append(ap.idle, idleConn{conn: conn, pc: pc, since: time.Now()})
+	ap.mu.Unlock()
+	select {
+	case ap.notify <- struct{}{}:
+	default:
+	}
+	return true, nil
+}
+
+// remove removes the closed connection from the idle list.
+func (ap *addrPool) remove(conn netpoll.Connection) {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	for i := range ap.idle {
+		if ap.idle[i].conn == conn {
+			ap.idle[i].pc.idle = false
+			copy(ap.idle[i:], ap.idle[i+1:])
+			ap.idle[len(ap.idle)-1] = idleConn{}
+			ap.idle = ap.idle[:len(ap.idle)-1]
+			return
+		}
+	}
+}
+
+// evict removes and returns the connections which have been idle since before deadline or closed by the peer.
+func (ap *addrPool) evict(deadline time.Time) (conns []netpoll.Connection) {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	var n int
+	for i := range ap.idle {
+		if ap.idle[i].since.Before(deadline) || !ap.idle[i].conn.IsActive() {
+			ap.idle[i].pc.idle = false
+			conns = append(conns, ap.idle[i].conn)
+			continue
+		}
+		ap.idle[n] = ap.idle[i]
+		n++
+	}
+	for i := n; i < len(ap.idle); i++ {
+		ap.idle[i] = idleConn{}
+	}
+	ap.idle = ap.idle[:n]
+	return conns
+}
+
+func (ap *addrPool) close() (conns []netpoll.Connection) {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	ap.closed = true
+	for i := range ap.idle {
+		ap.idle[i].pc.idle = false
+		conns = append(conns, ap.idle[i].conn)
+	}
+	ap.idle = nil
+	return conns
+}
@@ -0,0 +1,216 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package pool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+func MustNil(t *testing.T, val interface{}) {
+	t.Helper()
+	Assert(t, val == nil, val)
+	if val != nil {
+		t.Fatal("assertion nil failed, val=", val)
+	}
+}
+
+func MustTrue(t *testing.T, cond bool) {
+	t.Helper()
+	if !cond {
+		t.Fatal("assertion true failed.")
+	}
+}
+
+func Equal(t *testing.T, got, expect interface{}) {
+	t.Helper()
+	if got != expect {
+		t.Fatalf("assertion equal failed, got=[%v], expect=[%v]", got, expect)
+	}
+}
+
+func Assert(t *testing.T, cond bool, val ...interface{}) {
+	t.Helper()
+	if !cond {
+		if len(val) > 0 {
+			val = append([]interface{}{"assertion failed:"}, val...)
+			t.Fatal(val...)
+		} else {
+			t.Fatal("assertion failed")
+		}
+	}
+}
+
+func newTestServer(t *testing.T) (addr string, shutdown func()) {
+	ln, err := netpoll.CreateListener("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	el, _ := netpoll.NewEventLoop(func(ctx context.Context, connection netpoll.Connection) error {
+		buf, err := connection.Reader().Next(connection.Reader().Len())
+		if err != nil {
+			return err
+		}
+		connection.Writer().WriteBinary(buf)
+		return connection.Writer().Flush()
+	})
+	go el.Serve(ln)
+	return ln.Addr().String(), func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		el.Shutdown(ctx)
+	}
+}
+
+func TestConnPool(t *testing.T) {
+	addr, shutdown := newTestServer(t)
+	defer shutdown()
+	p := NewConnPool(Config{MaxIdle: 1})
+	defer p.Close()
+	ctx := context.Background()
+
+	c1, err := p.Get(ctx, addr)
+	MustNil(t, err)
+	c2, err := p.Get(ctx, addr)
+	MustNil(t, err)
+	MustTrue(t, c1 != c2)
+	active, idle := p.Len(addr)
+	Equal(t, active, 2)
+	Equal(t, idle, 0)
+
+	// echo
+	_, err = c1.Write([]byte("ping"))
+	MustNil(t, err)
+	s, err := c1.Reader().ReadString(4)
+	MustNil(t, err)
+	Equal(t, s, "ping")
+	c1.Reader().Release()
+
+	// the second one exceeds MaxIdle and is closed
+	MustNil(t, p.Put(c1))
+	MustNil(t, p.Put(c2))
+	MustTrue(t, !c2.IsActive())
+	active, idle = p.Len(addr)
+	Equal(t, active, 1)
+	Equal(t, idle, 1)
+
+	// reuse the idle one
+	c3, err := p.Get(ctx, addr)
+	MustNil(t, err)
+	MustTrue(t, c3 == c1)
+	MustNil(t, p.Put(c3))
+
+	// putting an idle connection again is rejected
+	MustTrue(t, errors.Is(p.Put(c3), ErrAlreadyIdle))
+	MustTrue(t, c3.IsActive())
+	active, idle = p.Len(addr)
+	Equal(t, active, 1)
+	Equal(t, idle, 1)
+
+	// closed by peer is discarded by Get
+	shutdown()
+	for i := 0; i < 100 && c3.IsActive(); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	MustTrue(t, !c3.IsActive())
+	_, err = p.Get(ctx, addr)
+	MustTrue(t, err != nil)
+	active, idle = p.Len(addr)
+	Equal(t, active, 0)
+	Equal(t, idle, 0)
+
+	// not got from the pool
+	MustTrue(t, errors.Is(p.Put(nil), ErrNotPooled))
+}
+
+func TestConnPoolMaxActive(t *testing.T) {
+	addr, shutdown := newTestServer(t)
+	defer shutdown()
+	p := NewConnPool(Config{MaxActive: 1})
+	defer p.Close()
+
+	c1, err := p.Get(context.Background(), addr)
+	MustNil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err = p.Get(ctx, addr)
+	cancel()
+	MustTrue(t, errors.Is(err, context.DeadlineExceeded))
+
+	// waiting for the connection put back
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		p.Put(c1)
+	}()
+	c2, err := p.Get(context.Background(), addr)
+	MustNil(t, err)
+	MustTrue(t, c2 == c1)
+
+	// waiting for the slot released by closing
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		c2.Close()
+	}()
+	c3, err := p.Get(context.Background(), addr)
+	MustNil(t, err)
+	MustTrue(t, c3 != c1)
+	c3.Close()
+}
+
+func TestConnPoolIdle(t *testing.T) {
+	addr, shutdown := newTestServer(t)
+	defer shutdown()
+	var checked int
+	p := NewConnPool(Config{
+		MaxIdle:     2,
+		IdleTimeout: 50 * time.Millisecond,
+		HealthCheck: func(conn netpoll.Connection) error {
+			checked++
+			return errors.New("unhealthy")
+		},
+	})
+	ctx := context.Background()
+
+	c1, err := p.Get(ctx, addr)
+	MustNil(t, err)
+	p.Put(c1)
+	// failed health check closes the idle connection
+	c2, err := p.Get(ctx, addr)
+	MustNil(t, err)
+	MustTrue(t, c2 != c1)
+	MustTrue(t, !c1.IsActive())
+	Equal(t, checked, 1)
+
+	// evicted after idle timeout
+	p.Put(c2)
+	time.Sleep(150 * time.Millisecond)
+	MustTrue(t, !c2.IsActive())
+	active, idle := p.Len(addr)
+	Equal(t, active, 0)
+	Equal(t, idle, 0)
+
+	// closed pool
+	c3, err := p.Get(ctx, addr)
+	MustNil(t, err)
+	MustNil(t, p.Close())
+	MustNil(t, p.Put(c3))
+	MustTrue(t, !c3.IsActive())
+	_, err = p.Get(ctx, addr)
+	Equal(t, err, ErrPoolClosed)
+}
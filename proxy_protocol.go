@@ -0,0 +1,251 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProxyProtocolConfig is the configuration of the PROXY protocol (v1 and v2) of HAProxy,
+// see https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt.
+type ProxyProtocolConfig struct {
+	// TrustedCIDRs are the source networks which are allowed to send the PROXY protocol header, e.g. the load balancers.
+	// The connections from other sources are treated as direct connections, and their header is not parsed.
+	// If empty, no source is trusted, so the load balancers must be listed explicitly,
+	// otherwise any client could forge its address with the header.
+	TrustedCIDRs []*net.IPNet
+
+	// HeaderTimeout is the timeout of reading the PROXY protocol header,
+	// and the connection is closed if the header is not received in time.
+	// If zero, defaults to 5s.
+	HeaderTimeout time.Duration
+}
+
+const defaultProxyHeaderTimeout = 5 * time.Second
+
+func (config *ProxyProtocolConfig) headerTimeout() time.Duration {
+	if config.HeaderTimeout > 0 {
+		return config.HeaderTimeout
+	}
+	return defaultProxyHeaderTimeout
+}
+
+// The types of PROXY protocol v2 TLVs.
+const (
+	ProxyTLVALPN      byte = 0x01
+	ProxyTLVAuthority byte = 0x02
+	ProxyTLVCRC32C    byte = 0x03
+	ProxyTLVNoop      byte = 0x04
+	ProxyTLVUniqueID  byte = 0x05
+	ProxyTLVSSL       byte = 0x20
+	ProxyTLVNetNS     byte = 0x30
+)
+
+// ProxyTLV is a Type-Length-Value of the PROXY protocol v2 header.
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// ProxyAddr is the RemoteAddr of the connections accepted with WithProxyProtocol,
+// which wraps the real client address carried by the PROXY protocol header.
+type ProxyAddr struct {
+	// Addr is the source address of the client.
+	net.Addr
+	// Destination is the destination address of the client, e.g. the address of the load balancer.
+	Destination net.Addr
+	// Proxy is the address of the proxy which sends the header.
+	Proxy net.Addr
+	// TLVs are the TLVs of the v2 header.
+	TLVs []ProxyTLV
+}
+
+// TLV returns the value of the first TLV of typ.
+func (a *ProxyAddr) TLV(typ byte) (value []byte, ok bool) {
+	for i := range a.TLVs {
+		if a.TLVs[i].Type == typ {
+			return a.TLVs[i].Value, true
+		}
+	}
+	return nil, false
+}
+
+var (
+	proxyV1Prefix    = []byte("PROXY")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+	errProxyHeader   = errors.New("invalid proxy protocol header")
+)
+
+const (
+	proxyV1MaxLength  = 107
+	proxyV2HeaderSize = 16
+)
+
+// parseProxyV1 parses the v1 header line (including CRLF), e.g. "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n".
+// It returns nil if the header does not carry the client address, e.g. "PROXY UNKNOWN\r\n".
+func parseProxyV1(line []byte) (addr *ProxyAddr, err error) {
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errProxyHeader
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, errProxyHeader
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, errProxyHeader
+	}
+	if len(fields) != 6 {
+		return nil, errProxyHeader
+	}
+	src, err := parseProxyV1Addr(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseProxyV1Addr(fields[1], fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+	return &ProxyAddr{Addr: src, Destination: dst}, nil
+}
+
+func parseProxyV1Addr(proto, host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (proto == "TCP4") != (ip.To4() != nil) {
+		return nil, errProxyHeader
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, errProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// parseProxyV2 parses the whole v2 header, including the 16 bytes fixed part.
+// It returns nil if the header does not carry the client address, e.g. the LOCAL command.
+func parseProxyV2(header []byte) (addr *ProxyAddr, err error) {
+	if len(header) < proxyV2HeaderSize || !bytes.Equal(header[:12], proxyV2Signature) {
+		return nil, errProxyHeader
+	}
+	if header[12]>>4 != 0x2 {
+		return nil, errProxyHeader
+	}
+	var payload = header[proxyV2HeaderSize:]
+	if int(binary.BigEndian.Uint16(header[14:16])) != len(payload) {
+		return nil, errProxyHeader
+	}
+	switch header[12] & 0x0f {
+	case 0x0: // LOCAL
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, errProxyHeader
+	}
+
+	var family, transport = header[13] >> 4, header[13] & 0x0f
+	var size int
+	switch family {
+	case 0x0: // UNSPEC
+	case 0x1: // INET
+		size = 2*net.IPv4len + 4
+	case 0x2: // INET6
+		size = 2*net.IPv6len + 4
+	case 0x3: // UNIX
+		size = 2 * 108
+	default:
+		return nil, errProxyHeader
+	}
+	if len(payload) < size {
+		return nil, errProxyHeader
+	}
+	addr = &ProxyAddr{}
+	switch family {
+	case 0x1, 0x2:
+		l := (size - 4) / 2
+		srcIP, dstIP := make(net.IP, l), make(net.IP, l)
+		copy(srcIP, payload[:l])
+		copy(dstIP, payload[l:2*l])
+		srcPort, dstPort := int(binary.BigEndian.Uint16(payload[2*l:])), int(binary.BigEndian.Uint16(payload[2*l+2:]))
+		if transport == 0x2 {
+			addr.Addr, addr.Destination = &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}
+		} else {
+			addr.Addr, addr.Destination = &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}
+		}
+	case 0x3:
+		var network = "unix"
+		if transport == 0x2 {
+			network = "unixgram"
+		}
+		addr.Addr = &net.UnixAddr{Name: proxyUnixPath(payload[:108]), Net: network}
+		addr.Destination = &net.UnixAddr{Name: proxyUnixPath(payload[108:216]), Net: network}
+	}
+
+	// TLVs
+	var tlvs = payload[size:]
+	for len(tlvs) > 0 {
+		if len(tlvs) < 3 {
+			return nil, errProxyHeader
+		}
+		l := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+l {
+			return nil, errProxyHeader
+		}
+		var tlv = ProxyTLV{Type: tlvs[0], Value: make([]byte, l)}
+		copy(tlv.Value, tlvs[3:3+l])
+		tlvs = tlvs[3+l:]
+		switch tlv.Type {
+		case ProxyTLVNoop:
+			continue
+		case ProxyTLVCRC32C:
+			if l != 4 || !proxyV2Checksum(header, len(header)-len(tlvs)-l, tlv.Value) {
+				return nil, errors.New("proxy protocol header checksum mismatch")
+			}
+		}
+		addr.TLVs = append(addr.TLVs, tlv)
+	}
+	if family == 0x0 {
+		// the receiver must ignore the address information of UNSPEC
+		return nil, nil
+	}
+	return addr, nil
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// proxyV2Checksum checks the CRC32c of the header, in which the checksum value at offset is zero.
+func proxyV2Checksum(header []byte, offset int, value []byte) bool {
+	var h = crc32.New(castagnoli)
+	h.Write(header[:offset])
+	h.Write([]byte{0, 0, 0, 0})
+	h.Write(header[offset+4:])
+	return h.Sum32() == binary.BigEndian.Uint32(value)
+}
+
+func proxyUnixPath(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
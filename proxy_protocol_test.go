@@ -0,0 +1,223 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseProxyV1(t *testing.T) {
+	addr, err := parseProxyV1([]byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"))
+	MustNil(t, err)
+	Equal(t, addr.String(), "192.0.2.1:56324")
+	Equal(t, addr.Destination.String(), "192.0.2.2:443")
+
+	addr, err = parseProxyV1([]byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"))
+	MustNil(t, err)
+	Equal(t, addr.String(), "[2001:db8::1]:56324")
+
+	addr, err = parseProxyV1([]byte("PROXY UNKNOWN\r\n"))
+	MustNil(t, err)
+	MustTrue(t, addr == nil)
+
+	for _, line := range []string{
+		"PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\n",
+		"PROXY TCP4 2001:db8::1 192.0.2.2 56324 443\r\n",
+		"PROXY TCP4 192.0.2.1 192.0.2.2 65536 443\r\n",
+		"PROXY UDP4 192.0.2.1 192.0.2.2 56324 443\r\n",
+		"PROXY TCP4 192.0.2.1\r\n",
+	} {
+		_, err = parseProxyV1([]byte(line))
+		MustTrue(t, err != nil)
+	}
+}
+
+func TestParseProxyV2(t *testing.T) {
+	header := mockProxyV2Header(&net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 56324}, &net.TCPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 443},
+		ProxyTLV{Type: ProxyTLVAuthority, Value: []byte("example.com")}, ProxyTLV{Type: ProxyTLVNoop, Value: []byte{0}})
+	addr, err := parseProxyV2(header)
+	MustNil(t, err)
+	Equal(t, addr.String(), "192.0.2.1:56324")
+	Equal(t, addr.Destination.String(), "192.0.2.2:443")
+	Equal(t, len(addr.TLVs), 1)
+	authority, ok := addr.TLV(ProxyTLVAuthority)
+	MustTrue(t, ok)
+	Equal(t, string(authority), "example.com")
+
+	// ipv6 with checksum
+	header = mockProxyV2Header(&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 56324}, &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443},
+		ProxyTLV{Type: ProxyTLVCRC32C, Value: make([]byte, 4)})
+	binary.BigEndian.PutUint32(header[len(header)-4:], crc32.Checksum(header, crc32.MakeTable(crc32.Castagnoli)))
+	addr, err = parseProxyV2(header)
+	MustNil(t, err)
+	Equal(t, addr.String(), "[2001:db8::1]:56324")
+	header[len(header)-1]++
+	_, err = parseProxyV2(header)
+	MustTrue(t, err != nil)
+
+	// LOCAL
+	header = mockProxyV2Header(nil, nil)
+	addr, err = parseProxyV2(header)
+	MustNil(t, err)
+	MustTrue(t, addr == nil)
+
+	// invalid
+	header = mockProxyV2Header(&net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 56324}, &net.TCPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 443})
+	_, err = parseProxyV2(header[:len(header)-1])
+	MustTrue(t, err != nil)
+	header[12] = 0x11 // version 1
+	_, err = parseProxyV2(header)
+	MustTrue(t, err != nil)
+}
+
+func TestProxyProtocol(t *testing.T) {
+	ln, err := CreateListener("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer ln.Close()
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	var addrs = make(chan net.Addr, 1)
+	el, _ := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		reader := connection.Reader()
+		buf, err := reader.Next(reader.Len())
+		if err != nil {
+			return err
+		}
+		Equal(t, string(buf), "hello")
+		addrs <- connection.RemoteAddr()
+		return nil
+	}, WithProxyProtocol(ProxyProtocolConfig{TrustedCIDRs: []*net.IPNet{loopback}, HeaderTimeout: 100 * time.Millisecond}))
+	go func() {
+		el.Serve(ln)
+	}()
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	defer el.Shutdown(ctx)
+
+	var send = func(header string) net.Addr {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		MustNil(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte(header + "hello"))
+		MustNil(t, err)
+		select {
+		case addr := <-addrs:
+			return addr
+		case <-time.After(time.Second):
+			t.Fatal("OnRequest is not triggered")
+		}
+		return nil
+	}
+	// v1
+	addr := send("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n")
+	proxyAddr, ok := addr.(*ProxyAddr)
+	MustTrue(t, ok)
+	Equal(t, proxyAddr.String(), "192.0.2.1:56324")
+	Equal(t, proxyAddr.Proxy.(*net.TCPAddr).IP.String(), "127.0.0.1")
+	// v2
+	addr = send(string(mockProxyV2Header(&net.TCPAddr{IP: net.IPv4(192, 0, 2, 3), Port: 1234}, &net.TCPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 443})))
+	Equal(t, addr.String(), "192.0.2.3:1234")
+	// LOCAL keeps the address of the proxy
+	addr = send(string(mockProxyV2Header(nil, nil)))
+	_, ok = addr.(*ProxyAddr)
+	MustTrue(t, !ok)
+
+	// invalid header and timeout
+	for _, header := range []string{"GET / HTTP/1.1\r\n", "PROXY"} {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		MustNil(t, err)
+		_, err = conn.Write([]byte(header))
+		MustNil(t, err)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		MustTrue(t, err != nil)
+		netErr, ok := err.(net.Error)
+		MustTrue(t, !ok || !netErr.Timeout()) // closed by server
+		conn.Close()
+	}
+}
+
+func TestProxyProtocolUntrusted(t *testing.T) {
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	// no source is trusted if TrustedCIDRs is empty
+	for _, cidrs := range [][]*net.IPNet{{trusted}, nil} {
+		testProxyProtocolUntrusted(t, ProxyProtocolConfig{TrustedCIDRs: cidrs})
+	}
+}
+
+func testProxyProtocolUntrusted(t *testing.T, config ProxyProtocolConfig) {
+	ln, err := CreateListener("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer ln.Close()
+	var addrs = make(chan net.Addr, 1)
+	el, _ := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		reader := connection.Reader()
+		_, err := reader.Next(reader.Len())
+		addrs <- connection.RemoteAddr()
+		return err
+	}, WithProxyProtocol(config))
+	go func() {
+		el.Serve(ln)
+	}()
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	defer el.Shutdown(ctx)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	MustNil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"))
+	MustNil(t, err)
+	select {
+	case addr := <-addrs:
+		_, ok := addr.(*ProxyAddr)
+		MustTrue(t, !ok)
+	case <-time.After(time.Second):
+		t.Fatal("OnRequest is not triggered")
+	}
+}
+
+// mockProxyV2Header returns the v2 header of the PROXY command, or the LOCAL command if src is nil.
+func mockProxyV2Header(src, dst *net.TCPAddr, tlvs ...ProxyTLV) []byte {
+	var header = append([]byte{}, proxyV2Signature...)
+	var payload []byte
+	if src == nil {
+		header = append(header, 0x20, 0x00)
+	} else if ip4 := src.IP.To4(); ip4 != nil {
+		header = append(header, 0x21, 0x11)
+		payload = append(payload, ip4...)
+		payload = append(payload, dst.IP.To4()...)
+	} else {
+		header = append(header, 0x21, 0x21)
+		payload = append(payload, src.IP.To16()...)
+		payload = append(payload, dst.IP.To16()...)
+	}
+	if src != nil {
+		payload = append(payload, byte(src.Port>>8), byte(src.Port), byte(dst.Port>>8), byte(dst.Port))
+	}
+	for _, tlv := range tlvs {
+		payload = append(payload, tlv.Type, byte(len(tlv.Value)>>8), byte(len(tlv.Value)))
+		payload = append(payload, tlv.Value...)
+	}
+	header = append(header, byte(len(payload)>>8), byte(len(payload)))
+	return append(header, payload...)
+}
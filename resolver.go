@@ -0,0 +1,271 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Resolver looks up the addresses of hosts and the ports of services for Dialer.
+// *net.Resolver implements Resolver, and net.DefaultResolver is used by default.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) (addrs []net.IPAddr, err error)
+
+	LookupPort(ctx context.Context, network, service string) (port int, err error)
+}
+
+// CacheResolverConfig is the configuration of the caching Resolver.
+type CacheResolverConfig struct {
+	// TTL is how long the addresses of a host are cached. Since the TTLs of DNS records
+	// are invisible to the standard resolver, it is a fixed value for all hosts.
+	// Default is 30s.
+	TTL time.Duration
+
+	// NegativeTTL is how long a failed lookup is cached, 0 means not caching the failures.
+	NegativeTTL time.Duration
+
+	// StaleTTL is how long the expired addresses can still be returned while refreshing
+	// them in the background, 0 means always waiting for the lookup after expired.
+	StaleTTL time.Duration
+
+	// Timeout is the timeout of looking up a host. The lookup is shared by the merged callers,
+	// so it is not canceled with the context of any caller, and each caller stops waiting
+	// when its own context is done. Default is 10s.
+	Timeout time.Duration
+}
+
+const (
+	defaultResolverTTL     = 30 * time.Second
+	defaultResolverTimeout = 10 * time.Second
+)
+
+// NewCacheResolver returns a Resolver which caches the addresses looked up by the resolver in memory.
+// The concurrent lookups of the same host are merged into one.
+// If resolver is nil, net.DefaultResolver is used.
+func NewCacheResolver(resolver Resolver, config CacheResolverConfig) Resolver {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	if config.TTL <= 0 {
+		config.TTL = defaultResolverTTL
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultResolverTimeout
+	}
+	return &cacheResolver{
+		resolver: resolver,
+		config:   config,
+		entries:  make(map[string]*cacheEntry),
+	}
+}
+
+type cacheResolver struct {
+	resolver  Resolver
+	config    CacheResolverConfig
+	mu        sync.Mutex
+	entries   map[string]*cacheEntry
+	lastPurge time.Time
+}
+
+// cacheEntry is immutable after ready is closed, except refreshing which is guarded by cacheResolver.mu.
+type cacheEntry struct {
+	addrs      []net.IPAddr
+	err        error
+	expire     time.Time
+	refreshing bool
+	ready      chan struct{}
+}
+
+// LookupIPAddr implements Resolver.
+func (r *cacheResolver) LookupIPAddr(ctx context.Context, host string) (addrs []net.IPAddr, err error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IPAddr{{IP: ip}}, nil
+	}
+	now := time.Now()
+	r.mu.Lock()
+	if e, ok := r.entries[host]; ok {
+		select {
+		case <-e.ready:
+			if now.Before(e.expire) {
+				r.mu.Unlock()
+				return copyAddrs(e.addrs), e.err
+			}
+			if e.err == nil && now.Before(e.expire.Add(r.config.StaleTTL)) {
+				if !e.refreshing {
+					e.refreshing = true
+					go r.refresh(host)
+				}
+				r.mu.Unlock()
+				return copyAddrs(e.addrs), nil
+			}
+		default:
+			// merge into the lookup in flight
+			r.mu.Unlock()
+			return r.wait(ctx, e)
+		}
+	}
+	r.purge(now)
+	e := &cacheEntry{ready: make(chan struct{})}
+	r.entries[host] = e
+	r.mu.Unlock()
+
+	go r.lookup(host, e)
+	return r.wait(ctx, e)
+}
+
+// lookup looks up the host for the entry in flight, which runs with its own timeout
+// instead of the context of any caller, since the result is shared by the merged callers.
+func (r *cacheResolver) lookup(host string, e *cacheEntry) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.config.Timeout)
+	defer cancel()
+	addrs, err := r.resolver.LookupIPAddr(ctx, host)
+	r.complete(host, e, addrs, err)
+}
+
+// wait waits for the lookup of the entry until ctx is done.
+func (r *cacheResolver) wait(ctx context.Context, e *cacheEntry) (addrs []net.IPAddr, err error) {
+	select {
+	case <-e.ready:
+		return copyAddrs(e.addrs), e.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// copyAddrs returns a copy of the cached addresses, so the callers cannot modify the cache.
+func copyAddrs(addrs []net.IPAddr) []net.IPAddr {
+	if addrs == nil {
+		return nil
+	}
+	var copied = make([]net.IPAddr, len(addrs))
+	for i, addr := range addrs {
+		copied[i] = net.IPAddr{IP: append(net.IP(nil), addr.IP...), Zone: addr.Zone}
+	}
+	return copied
+}
+
+// LookupPort implements Resolver.
+func (r *cacheResolver) LookupPort(ctx context.Context, network, service string) (port int, err error) {
+	return r.resolver.LookupPort(ctx, network, service)
+}
+
+func (r *cacheResolver) complete(host string, e *cacheEntry, addrs []net.IPAddr, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.addrs, e.err = addrs, err
+	switch {
+	case err == nil:
+		e.expire = time.Now().Add(r.config.TTL)
+	case r.config.NegativeTTL > 0 && ctxErr(err) == nil:
+		e.expire = time.Now().Add(r.config.NegativeTTL)
+	default:
+		// not cached, but the merged lookups still get the error.
+		if r.entries[host] == e {
+			delete(r.entries, host)
+		}
+	}
+	close(e.ready)
+}
+
+// refresh looks up the host in the background, and the stale addresses are kept if it fails.
+func (r *cacheResolver) refresh(host string) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.config.Timeout)
+	defer cancel()
+	addrs, err := r.resolver.LookupIPAddr(ctx, host)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		if e, ok := r.entries[host]; ok {
+			e.refreshing = false
+		}
+		return
+	}
+	e := &cacheEntry{addrs: addrs, expire: time.Now().Add(r.config.TTL), ready: make(chan struct{})}
+	close(e.ready)
+	r.entries[host] = e
+}
+
+// purge deletes the expired entries at most once per TTL, which must be called with mu held.
+func (r *cacheResolver) purge(now time.Time) {
+	if now.Sub(r.lastPurge) < r.config.TTL {
+		return
+	}
+	r.lastPurge = now
+	for host, e := range r.entries {
+		select {
+		case <-e.ready:
+			if now.After(e.expire.Add(r.config.StaleTTL)) {
+				delete(r.entries, host)
+			}
+		default:
+		}
+	}
+}
+
+// ctxErr returns err if it is caused by the context.
+func ctxErr(err error) error {
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return err
+	}
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsTimeout {
+		return err
+	}
+	return nil
+}
+
+// NewHostsResolver returns a Resolver which looks up hosts from the static hosts map only,
+// which is usually used in tests.
+func NewHostsResolver(hosts map[string][]net.IP) Resolver {
+	var r = &hostsResolver{hosts: make(map[string][]net.IPAddr, len(hosts))}
+	for host, ips := range hosts {
+		var addrs = make([]net.IPAddr, len(ips))
+		for i := range ips {
+			addrs[i].IP = ips[i]
+		}
+		r.hosts[host] = addrs
+	}
+	return r
+}
+
+type hostsResolver struct {
+	hosts map[string][]net.IPAddr
+}
+
+// LookupIPAddr implements Resolver.
+func (r *hostsResolver) LookupIPAddr(ctx context.Context, host string) (addrs []net.IPAddr, err error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IPAddr{{IP: ip}}, nil
+	}
+	if addrs, ok := r.hosts[host]; ok {
+		return copyAddrs(addrs), nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// LookupPort implements Resolver.
+func (r *hostsResolver) LookupPort(ctx context.Context, network, service string) (port int, err error) {
+	if port, err = strconv.Atoi(service); err == nil {
+		if port < 0 || port > 0xFFFF {
+			return 0, &net.AddrError{Err: "invalid port", Addr: service}
+		}
+		return port, nil
+	}
+	return net.DefaultResolver.LookupPort(ctx, network, service)
+}
@@ -0,0 +1,206 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type mockResolver struct {
+	lookups int32
+	delay   time.Duration
+	mu      sync.Mutex
+	addrs   []net.IPAddr
+	err     error
+}
+
+func (r *mockResolver) set(addrs []net.IPAddr, err error) {
+	r.mu.Lock()
+	r.addrs, r.err = addrs, err
+	r.mu.Unlock()
+}
+
+func (r *mockResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	atomic.AddInt32(&r.lookups, 1)
+	select {
+	case <-time.After(r.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.addrs, r.err
+}
+
+func (r *mockResolver) LookupPort(ctx context.Context, network, service string) (int, error) {
+	return net.DefaultResolver.LookupPort(ctx, network, service)
+}
+
+func TestCacheResolver(t *testing.T) {
+	var ctx = context.Background()
+	var mock = &mockResolver{delay: 10 * time.Millisecond}
+	mock.set([]net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}, nil)
+	r := NewCacheResolver(mock, CacheResolverConfig{TTL: 50 * time.Millisecond})
+
+	// concurrent lookups are merged
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addrs, err := r.LookupIPAddr(ctx, "example.com")
+			MustNil(t, err)
+			Equal(t, len(addrs), 1)
+		}()
+	}
+	wg.Wait()
+	Equal(t, atomic.LoadInt32(&mock.lookups), int32(1))
+
+	// the cached addresses cannot be modified by the callers
+	addrs, err := r.LookupIPAddr(ctx, "example.com")
+	MustNil(t, err)
+	addrs[0].IP[0] = 10
+	addrs[0] = net.IPAddr{}
+	addrs, err = r.LookupIPAddr(ctx, "example.com")
+	MustNil(t, err)
+	MustTrue(t, addrs[0].IP.Equal(net.IPv4(127, 0, 0, 1)))
+
+	// literal IP is not looked up
+	addrs, err = r.LookupIPAddr(ctx, "::1")
+	MustNil(t, err)
+	MustTrue(t, addrs[0].IP.Equal(net.IPv6loopback))
+	Equal(t, atomic.LoadInt32(&mock.lookups), int32(1))
+
+	// expired
+	time.Sleep(60 * time.Millisecond)
+	_, err = r.LookupIPAddr(ctx, "example.com")
+	MustNil(t, err)
+	Equal(t, atomic.LoadInt32(&mock.lookups), int32(2))
+
+	// failures are not cached by default
+	mock.set(nil, errors.New("mock error"))
+	_, err = r.LookupIPAddr(ctx, "failure.com")
+	MustTrue(t, err != nil)
+	_, err = r.LookupIPAddr(ctx, "failure.com")
+	MustTrue(t, err != nil)
+	Equal(t, atomic.LoadInt32(&mock.lookups), int32(4))
+}
+
+func TestCacheResolverCanceled(t *testing.T) {
+	var mock = &mockResolver{delay: 50 * time.Millisecond}
+	mock.set([]net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}, nil)
+	r := NewCacheResolver(mock, CacheResolverConfig{TTL: time.Minute, NegativeTTL: time.Minute})
+
+	// the first caller gives up, but the merged caller still gets the addresses.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	var done = make(chan error, 1)
+	go func() {
+		_, err := r.LookupIPAddr(ctx, "example.com")
+		done <- err
+	}()
+	time.Sleep(time.Millisecond)
+	addrs, err := r.LookupIPAddr(context.Background(), "example.com")
+	MustNil(t, err)
+	Equal(t, len(addrs), 1)
+	MustTrue(t, errors.Is(<-done, context.DeadlineExceeded))
+	Equal(t, atomic.LoadInt32(&mock.lookups), int32(1))
+
+	// and the addresses are cached
+	addrs, err = r.LookupIPAddr(context.Background(), "example.com")
+	MustNil(t, err)
+	Equal(t, len(addrs), 1)
+	Equal(t, atomic.LoadInt32(&mock.lookups), int32(1))
+}
+
+func TestCacheResolverNegative(t *testing.T) {
+	var ctx = context.Background()
+	var mock = &mockResolver{}
+	mock.set(nil, errors.New("mock error"))
+	r := NewCacheResolver(mock, CacheResolverConfig{TTL: time.Second, NegativeTTL: 50 * time.Millisecond})
+
+	_, err := r.LookupIPAddr(ctx, "example.com")
+	MustTrue(t, err != nil)
+	_, err = r.LookupIPAddr(ctx, "example.com")
+	MustTrue(t, err != nil)
+	Equal(t, atomic.LoadInt32(&mock.lookups), int32(1))
+
+	time.Sleep(60 * time.Millisecond)
+	mock.set([]net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}, nil)
+	addrs, err := r.LookupIPAddr(ctx, "example.com")
+	MustNil(t, err)
+	Equal(t, len(addrs), 1)
+	Equal(t, atomic.LoadInt32(&mock.lookups), int32(2))
+}
+
+func TestCacheResolverStale(t *testing.T) {
+	var ctx = context.Background()
+	var mock = &mockResolver{}
+	mock.set([]net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}, nil)
+	r := NewCacheResolver(mock, CacheResolverConfig{TTL: 50 * time.Millisecond, StaleTTL: time.Second})
+
+	_, err := r.LookupIPAddr(ctx, "example.com")
+	MustNil(t, err)
+	time.Sleep(60 * time.Millisecond)
+
+	// the stale addresses are returned and refreshed in the background
+	mock.set([]net.IPAddr{{IP: net.IPv4(127, 0, 0, 2)}}, nil)
+	addrs, err := r.LookupIPAddr(ctx, "example.com")
+	MustNil(t, err)
+	MustTrue(t, addrs[0].IP.Equal(net.IPv4(127, 0, 0, 1)))
+	for i := 0; i < 100 && !addrs[0].IP.Equal(net.IPv4(127, 0, 0, 2)); i++ {
+		time.Sleep(time.Millisecond)
+		addrs, err = r.LookupIPAddr(ctx, "example.com")
+		MustNil(t, err)
+	}
+	MustTrue(t, addrs[0].IP.Equal(net.IPv4(127, 0, 0, 2)))
+	Equal(t, atomic.LoadInt32(&mock.lookups), int32(2))
+}
+
+func TestHostsResolver(t *testing.T) {
+	ln, err := CreateListener("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	r := NewHostsResolver(map[string][]net.IP{
+		"netpoll.test": {net.IPv4(127, 0, 0, 1)},
+	})
+	_, err = r.LookupIPAddr(context.Background(), "unknown.test")
+	var dnsErr *net.DNSError
+	MustTrue(t, errors.As(err, &dnsErr) && dnsErr.IsNotFound)
+	// the hosts cannot be modified by the callers
+	addrs, err := r.LookupIPAddr(context.Background(), "netpoll.test")
+	MustNil(t, err)
+	addrs[0].IP[0] = 10
+	addrs, err = r.LookupIPAddr(context.Background(), "netpoll.test")
+	MustNil(t, err)
+	MustTrue(t, addrs[0].IP.Equal(net.IPv4(127, 0, 0, 1)))
+
+	dialer := NewDialer(WithDialResolver(r))
+	conn, err := dialer.DialConnection("tcp", net.JoinHostPort("netpoll.test", port), time.Second)
+	MustNil(t, err)
+	Equal(t, conn.RemoteAddr().String(), ln.Addr().String())
+	conn.Close()
+}
@@ -18,9 +18,11 @@
 package netpoll
 
 import (
+	"errors"
 	"math"
 	"os"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -35,6 +37,35 @@ func setTCPNoDelay(fd int, b bool) (err error) {
 	return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY, boolint(b))
 }
 
+// setLinger sets SO_LINGER on socket, sec < 0 means the default behavior that
+// closes the socket in background.
+func setLinger(fd int, sec int) error {
+	var l syscall.Linger
+	if sec >= 0 {
+		l.Onoff, l.Linger = 1, int32(sec)
+	}
+	return syscall.SetsockoptLinger(fd, syscall.SOL_SOCKET, syscall.SO_LINGER, &l)
+}
+
+// setReadBuffer sets the SO_RCVBUF on socket.
+func setReadBuffer(fd int, bytes int) error {
+	return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, bytes)
+}
+
+// setWriteBuffer sets the SO_SNDBUF on socket.
+func setWriteBuffer(fd int, bytes int) error {
+	return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, bytes)
+}
+
+// roundSeconds rounds the duration up to seconds, which is at least 1.
+func roundSeconds(d time.Duration) int {
+	secs := int((d + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
+
 // Wrapper around the socket system call that marks the returned file
 // descriptor as nonblocking and close-on-exec.
 func sysSocket(family, sotype, proto int) (int, error) {
@@ -93,6 +124,42 @@ func readv(fd int, bs [][]byte, ivs []syscall.Iovec) (n int, err error) {
 	return int(r), nil
 }
 
+// pendingBytes returns the size of data to read by ioctl.
+func pendingBytes(fd int) (n int, err error) {
+	var v int32
+	_, _, e := syscall.RawSyscall(syscall.SYS_IOCTL, uintptr(fd), fionread, uintptr(unsafe.Pointer(&v)))
+	if e != 0 {
+		return 0, syscall.Errno(e)
+	}
+	return int(v), nil
+}
+
+var (
+	errMessageTruncated = errors.New("message truncated")
+	errControlTruncated = errors.New("control message truncated")
+)
+
+// readv reads the input of FDOperator, including the control messages if required.
+// It fails if the message or the control messages are truncated, e.g. some file descriptors are lost.
+func (op *FDOperator) readv(bs [][]byte, ivs []syscall.Iovec) (n int, err error) {
+	if op.control == nil {
+		return readv(op.FD, bs, ivs)
+	}
+	n, oobn, flags, err := recvmsg(op.FD, bs, ivs, op.control)
+	if oobn > 0 {
+		op.controlAck(op.control[:oobn])
+	}
+	switch {
+	case err != nil:
+		return n, err
+	case flags&syscall.MSG_TRUNC != 0:
+		return -1, errMessageTruncated
+	case flags&syscall.MSG_CTRUNC != 0:
+		return -1, errControlTruncated
+	}
+	return n, nil
+}
+
 // TODO: read from sysconf(_SC_IOV_MAX)? The Linux default is
 //  1024 and this seems conservative enough for now. Darwin's
 //  UIO_MAXIOV also seems to be 1024.
@@ -277,3 +277,37 @@ func BenchmarkReadv(b *testing.B) {
 		readv(r, barrier.bs, barrier.ivs)
 	}
 }
+
+func TestReadvTruncated(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET, 0)
+	MustNil(t, err)
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+	var received []int
+	var op = &FDOperator{FD: fds[0], control: make([]byte, syscall.CmsgSpace(4))}
+	op.controlAck = func(oob []byte) {
+		fds, _, _ := parseUnixControl(oob)
+		received = append(received, fds...)
+	}
+	var ivs = make([]syscall.Iovec, 1)
+	var readv = func() (int, error) {
+		return op.readv([][]byte{make([]byte, 4)}, ivs)
+	}
+
+	// the message is larger than the buffer
+	MustNil(t, syscall.Sendmsg(fds[1], []byte("hello"), nil, nil, 0))
+	_, err = readv()
+	Assert(t, err == errMessageTruncated, err)
+
+	// the file descriptors are more than the control buffer can hold
+	MustNil(t, syscall.Sendmsg(fds[1], []byte("fd"), syscall.UnixRights(fds[1], fds[1], fds[1]), nil, 0))
+	_, err = readv()
+	Assert(t, err == errControlTruncated, err)
+	closeFds(received)
+
+	// the empty message
+	MustNil(t, syscall.Sendmsg(fds[1], nil, nil, nil, 0))
+	n, err := readv()
+	MustNil(t, err)
+	Equal(t, n, 0)
+}
@@ -14,7 +14,10 @@
 
 package netpoll
 
-import "syscall"
+import (
+	"syscall"
+	"time"
+)
 
 // SetKeepAlive sets the keepalive for the connection
 func SetKeepAlive(fd, secs int) error {
@@ -28,3 +31,27 @@ func SetKeepAlive(fd, secs int) error {
 	}
 	return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPALIVE, secs)
 }
+
+// setKeepAlive enables TCP keep-alive with the given idle time, probe interval and probe count,
+// or disables it if idle <= 0. The interval and count keep the system defaults if <= 0.
+func setKeepAlive(fd int, idle, interval time.Duration, count int) error {
+	if idle <= 0 {
+		return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 0)
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1); err != nil {
+		return err
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPALIVE, roundSeconds(idle)); err != nil {
+		return err
+	}
+	// TCP_KEEPINTVL and TCP_KEEPCNT are not defined in syscall on darwin.
+	if interval > 0 {
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, 0x101, roundSeconds(interval)); err != nil {
+			return err
+		}
+	}
+	if count > 0 {
+		return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, 0x102, count)
+	}
+	return nil
+}
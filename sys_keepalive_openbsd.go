@@ -14,8 +14,18 @@
 
 package netpoll
 
+import (
+	"syscall"
+	"time"
+)
+
 // SetKeepAlive sets the keepalive for the connection
 func SetKeepAlive(fd, secs int) error {
 	// OpenBSD has no user-settable per-socket TCP keepalive options.
 	return nil
 }
+
+// setKeepAlive only switches SO_KEEPALIVE, the idle, interval and count are system-wide on OpenBSD.
+func setKeepAlive(fd int, idle, interval time.Duration, count int) error {
+	return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, boolint(idle > 0))
+}
@@ -17,7 +17,10 @@
 
 package netpoll
 
-import "syscall"
+import (
+	"syscall"
+	"time"
+)
 
 // just support ipv4
 func SetKeepAlive(fd, secs int) error {
@@ -36,3 +39,26 @@ func SetKeepAlive(fd, secs int) error {
 	// tcp_keepalive_time
 	return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, secs)
 }
+
+// setKeepAlive enables TCP keep-alive with the given idle time, probe interval and probe count,
+// or disables it if idle <= 0. The interval and count keep the system defaults if <= 0.
+func setKeepAlive(fd int, idle, interval time.Duration, count int) error {
+	if idle <= 0 {
+		return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 0)
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1); err != nil {
+		return err
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, roundSeconds(idle)); err != nil {
+		return err
+	}
+	if interval > 0 {
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, roundSeconds(interval)); err != nil {
+			return err
+		}
+	}
+	if count > 0 {
+		return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, count)
+	}
+	return nil
+}
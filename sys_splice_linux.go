@@ -0,0 +1,101 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"runtime"
+	"sync"
+	"syscall"
+)
+
+const (
+	spliceMove     = 0x1 // SPLICE_F_MOVE
+	spliceNonblock = 0x2 // SPLICE_F_NONBLOCK
+
+	// splicePipeSize is the expected capacity of the pipes, which may be limited by /proc/sys/fs/pipe-max-size.
+	splicePipeSize = 1 << 20
+)
+
+// splicePipe is the pipe used to splice data between sockets, data is the bytes left in the pipe.
+type splicePipe struct {
+	rfd, wfd int
+	size     int
+	data     int
+}
+
+var splicePipePool sync.Pool
+
+func newSplicePipe() (*splicePipe, error) {
+	var fds [2]int
+	if err := syscall.Pipe2(fds[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+		return nil, err
+	}
+	var p = &splicePipe{rfd: fds[0], wfd: fds[1]}
+	// enlarging the pipe is optional.
+	fcntl(p.wfd, syscall.F_SETPIPE_SZ, splicePipeSize)
+	if size, err := fcntl(p.wfd, syscall.F_GETPIPE_SZ, 0); err == nil && size > 0 {
+		p.size = size
+	} else {
+		p.size = 1 << 16
+	}
+	return p, nil
+}
+
+// getSplicePipe returns a pipe from the pool, or creates a new one.
+func getSplicePipe() (*splicePipe, error) {
+	if p, ok := splicePipePool.Get().(*splicePipe); ok {
+		return p, nil
+	}
+	p, err := newSplicePipe()
+	if err != nil {
+		return nil, err
+	}
+	// the pipes dropped by the pool are closed by the finalizer.
+	runtime.SetFinalizer(p, (*splicePipe).close)
+	return p, nil
+}
+
+// putSplicePipe puts the pipe back to the pool if it is empty, otherwise it is closed.
+func putSplicePipe(p *splicePipe) {
+	if p.data != 0 {
+		runtime.SetFinalizer(p, nil)
+		p.close()
+		return
+	}
+	splicePipePool.Put(p)
+}
+
+func (p *splicePipe) close() {
+	syscall.Close(p.rfd)
+	syscall.Close(p.wfd)
+}
+
+// splice moves at most n bytes from rfd to wfd without blocking.
+func splice(rfd, wfd, n int) (m int, err error) {
+	for {
+		r, err := syscall.Splice(rfd, nil, wfd, nil, n, spliceMove|spliceNonblock)
+		if err != syscall.EINTR {
+			return int(r), err
+		}
+	}
+}
+
+func fcntl(fd, cmd, arg int) (int, error) {
+	r, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), uintptr(cmd), uintptr(arg))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
@@ -0,0 +1,44 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// getTCPInfo wraps getsockopt(TCP_INFO).
+func getTCPInfo(fd int) (*TCPInfo, error) {
+	var raw syscall.TCPInfo
+	var size = uint32(syscall.SizeofTCPInfo)
+	_, _, e := syscall.Syscall6(syscall.SYS_GETSOCKOPT, uintptr(fd), syscall.IPPROTO_TCP, syscall.TCP_INFO,
+		uintptr(unsafe.Pointer(&raw)), uintptr(unsafe.Pointer(&size)), 0)
+	if e != 0 {
+		return nil, os.NewSyscallError("getsockopt", e)
+	}
+	return &TCPInfo{
+		RTT:          time.Duration(raw.Rtt) * time.Microsecond,
+		RTTVar:       time.Duration(raw.Rttvar) * time.Microsecond,
+		RTO:          time.Duration(raw.Rto) * time.Microsecond,
+		Retransmits:  uint32(raw.Retransmits),
+		TotalRetrans: raw.Total_retrans,
+		Lost:         raw.Lost,
+		Unacked:      raw.Unacked,
+		SndMSS:       raw.Snd_mss,
+		SndCwnd:      raw.Snd_cwnd,
+	}, nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package netpoll
+
+import (
+	"time"
+)
+
+func setTCPQuickAck(fd int, b bool) error {
+	return Exception(ErrUnsupported, "TCP_QUICKACK")
+}
+
+// setTCPCork uses TCP_NOPUSH on BSD, which is similar to TCP_CORK on linux.
+func setTCPCork(fd int, b bool) error {
+	return setTCPNoPush(fd, b)
+}
+
+func setTCPUserTimeout(fd int, timeout time.Duration) error {
+	return Exception(ErrUnsupported, "TCP_USER_TIMEOUT")
+}
+
+func setTCPCongestion(fd int, name string) error {
+	return Exception(ErrUnsupported, "TCP_CONGESTION")
+}
+
+func setTCPFastOpen(fd int, queue int) error {
+	return Exception(ErrUnsupported, "TCP_FASTOPEN")
+}
+
+func setTCPFastOpenConnect(fd int) error {
+	return Exception(ErrUnsupported, "TCP_FASTOPEN_CONNECT")
+}
+
+func setMark(fd int, mark int) error {
+	return Exception(ErrUnsupported, "SO_MARK")
+}
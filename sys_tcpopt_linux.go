@@ -0,0 +1,57 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"syscall"
+	"time"
+)
+
+// TCP_USER_TIMEOUT, TCP_FASTOPEN and TCP_FASTOPEN_CONNECT are not defined in syscall.
+const (
+	tcpUserTimeout     = 0x12
+	tcpFastOpen        = 0x17
+	tcpFastOpenConnect = 0x1e
+)
+
+func setTCPQuickAck(fd int, b bool) error {
+	return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_QUICKACK, boolint(b))
+}
+
+func setTCPCork(fd int, b bool) error {
+	return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_CORK, boolint(b))
+}
+
+func setTCPUserTimeout(fd int, timeout time.Duration) error {
+	return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, tcpUserTimeout, int(timeout/time.Millisecond))
+}
+
+func setTCPCongestion(fd int, name string) error {
+	return syscall.SetsockoptString(fd, syscall.IPPROTO_TCP, syscall.TCP_CONGESTION, name)
+}
+
+// setTCPFastOpen enables TCP Fast Open on the listening socket with the queue of pending TFO requests.
+func setTCPFastOpen(fd int, queue int) error {
+	return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, tcpFastOpen, queue)
+}
+
+// setTCPFastOpenConnect makes connect return immediately and the SYN is sent with the first write, since linux 4.11.
+func setTCPFastOpenConnect(fd int) error {
+	return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, tcpFastOpenConnect, 1)
+}
+
+func setMark(fd int, mark int) error {
+	return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_MARK, mark)
+}
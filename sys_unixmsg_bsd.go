@@ -0,0 +1,125 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package netpoll
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// unixControlSize is the size of the control buffer of unix connections,
+// which holds at most 253 file descriptors.
+var unixControlSize = syscall.CmsgSpace(253 * 4)
+
+// fionread is FIONREAD, which returns the size of all the pending data,
+// and it is enough to hold the next message.
+const fionread = 0x4004667f
+
+// recvmsg wraps the recvmsg system call, which returns the flags of the received message, e.g. MSG_TRUNC.
+// return 0, 0, 0, nil means EOF, or an empty message of the message-oriented sockets.
+func recvmsg(fd int, bs [][]byte, ivs []syscall.Iovec, oob []byte) (n, oobn, flags int, err error) {
+	iovLen := iovecs(bs, ivs)
+	if iovLen == 0 {
+		return 0, 0, 0, nil
+	}
+	var msghdr = syscall.Msghdr{
+		Iov: &ivs[0],
+	}
+	setIovlen(&msghdr, iovLen)
+	if len(oob) > 0 {
+		msghdr.Control = &oob[0]
+		msghdr.SetControllen(len(oob))
+	}
+	r, _, e := syscall.RawSyscall(syscall.SYS_RECVMSG, uintptr(fd), uintptr(unsafe.Pointer(&msghdr)), 0)
+	resetIovecs(bs, ivs[:iovLen])
+	if e != 0 {
+		return int(r), 0, 0, syscall.Errno(e)
+	}
+	return int(r), int(msghdr.Controllen), int(msghdr.Flags), nil
+}
+
+// pollFd is struct pollfd of poll(2).
+type pollFd struct {
+	fd      int32
+	events  int16
+	revents int16
+}
+
+const (
+	pollIn  = 0x1
+	pollHup = 0x10
+)
+
+// peerShutdown reports whether the peer has shut down by polling without blocking, which tells
+// EOF from the empty message since both of them are read as zero bytes from the message-oriented sockets.
+func peerShutdown(fd int) bool {
+	var pfd = pollFd{fd: int32(fd), events: pollIn}
+	n, _, e := syscall.Syscall(syscall.SYS_POLL, uintptr(unsafe.Pointer(&pfd)), 1, 0)
+	return e == 0 && n > 0 && pfd.revents&pollHup != 0
+}
+
+// parseUnixControl parses the file descriptors (SCM_RIGHTS).
+func parseUnixControl(oob []byte) (fds []int, cred *UnixCredentials, err error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range msgs {
+		if msgs[i].Header.Level != syscall.SOL_SOCKET || msgs[i].Header.Type != syscall.SCM_RIGHTS {
+			continue
+		}
+		rights, err := syscall.ParseUnixRights(&msgs[i])
+		if err != nil {
+			return fds, nil, err
+		}
+		for _, fd := range rights {
+			syscall.CloseOnExec(fd)
+		}
+		fds = append(fds, rights...)
+	}
+	return fds, nil, nil
+}
+
+func unixCredentials(cred *UnixCredentials) ([]byte, error) {
+	return nil, Exception(ErrUnsupported, "SCM_CREDENTIALS")
+}
+
+func getPeerCredentials(fd int) (*UnixCredentials, error) {
+	return nil, Exception(ErrUnsupported, "SO_PEERCRED")
+}
+
+func setPassCred(fd int, on bool) error {
+	return Exception(ErrUnsupported, "SO_PASSCRED")
+}
+
+// getUnixName returns the name of the local or peer address of unix socket.
+func getUnixName(fd int, peer bool) (name string, err error) {
+	var sa syscall.Sockaddr
+	if peer {
+		sa, err = syscall.Getpeername(fd)
+	} else {
+		sa, err = syscall.Getsockname(fd)
+	}
+	if err != nil {
+		return "", err
+	}
+	if sa, ok := sa.(*syscall.SockaddrUnix); ok {
+		return sa.Name, nil
+	}
+	return "", nil
+}
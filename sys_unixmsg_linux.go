@@ -0,0 +1,156 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// unixControlSize is the size of the control buffer of unix connections,
+// which holds at most 253 (SCM_MAX_FD) file descriptors and the credentials.
+var unixControlSize = syscall.CmsgSpace(253*4) + syscall.CmsgSpace(syscall.SizeofUcred)
+
+// fionread is SIOCINQ, which returns the size of the next message of the message-oriented unix sockets.
+const fionread = 0x541b
+
+// recvmsg wraps the recvmsg system call, which returns the flags of the received message, e.g. MSG_TRUNC.
+// return 0, 0, 0, nil means EOF, or an empty message of the message-oriented sockets.
+func recvmsg(fd int, bs [][]byte, ivs []syscall.Iovec, oob []byte) (n, oobn, flags int, err error) {
+	iovLen := iovecs(bs, ivs)
+	if iovLen == 0 {
+		return 0, 0, 0, nil
+	}
+	var msghdr = syscall.Msghdr{
+		Iov:    &ivs[0],
+		Iovlen: uint64(iovLen),
+	}
+	if len(oob) > 0 {
+		msghdr.Control = &oob[0]
+		msghdr.SetControllen(len(oob))
+	}
+	r, _, e := syscall.RawSyscall(syscall.SYS_RECVMSG, uintptr(fd), uintptr(unsafe.Pointer(&msghdr)), syscall.MSG_CMSG_CLOEXEC)
+	resetIovecs(bs, ivs[:iovLen])
+	if e != 0 {
+		return int(r), 0, 0, syscall.Errno(e)
+	}
+	return int(r), int(msghdr.Controllen), int(msghdr.Flags), nil
+}
+
+// pollFd is struct pollfd of poll(2).
+type pollFd struct {
+	fd      int32
+	events  int16
+	revents int16
+}
+
+const (
+	pollHup   = 0x10
+	pollRdHup = 0x2000
+)
+
+// peerShutdown reports whether the peer has shut down writing by polling without blocking, which tells
+// EOF from the empty message since both of them are read as zero bytes from the message-oriented sockets.
+func peerShutdown(fd int) bool {
+	var pfd = pollFd{fd: int32(fd), events: pollRdHup}
+	var ts syscall.Timespec
+	n, _, e := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&pfd)), 1, uintptr(unsafe.Pointer(&ts)), 0, 0, 0)
+	return e == 0 && n > 0 && pfd.revents&(pollHup|pollRdHup) != 0
+}
+
+// parseUnixControl parses the file descriptors (SCM_RIGHTS) and the credentials (SCM_CREDENTIALS).
+func parseUnixControl(oob []byte) (fds []int, cred *UnixCredentials, err error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range msgs {
+		if msgs[i].Header.Level != syscall.SOL_SOCKET {
+			continue
+		}
+		switch msgs[i].Header.Type {
+		case syscall.SCM_RIGHTS:
+			rights, err := syscall.ParseUnixRights(&msgs[i])
+			if err != nil {
+				return fds, cred, err
+			}
+			fds = append(fds, rights...)
+		case syscall.SCM_CREDENTIALS:
+			ucred, err := syscall.ParseUnixCredentials(&msgs[i])
+			if err != nil {
+				return fds, cred, err
+			}
+			cred = &UnixCredentials{Pid: ucred.Pid, Uid: ucred.Uid, Gid: ucred.Gid}
+		}
+	}
+	return fds, cred, nil
+}
+
+// unixCredentials returns the control message of SCM_CREDENTIALS.
+func unixCredentials(cred *UnixCredentials) ([]byte, error) {
+	return syscall.UnixCredentials(&syscall.Ucred{Pid: cred.Pid, Uid: cred.Uid, Gid: cred.Gid}), nil
+}
+
+// getPeerCredentials returns the credentials of the peer by SO_PEERCRED.
+func getPeerCredentials(fd int) (*UnixCredentials, error) {
+	ucred, err := syscall.GetsockoptUcred(fd, syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	if err != nil {
+		return nil, err
+	}
+	return &UnixCredentials{Pid: ucred.Pid, Uid: ucred.Uid, Gid: ucred.Gid}, nil
+}
+
+// setPassCred sets SO_PASSCRED, which is required to receive SCM_CREDENTIALS.
+func setPassCred(fd int, on bool) error {
+	return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_PASSCRED, boolint(on))
+}
+
+// getUnixName returns the name of the local or peer address of unix socket,
+// in which the abstract name (Linux-specific) begins with '@' and the unnamed address is empty.
+func getUnixName(fd int, peer bool) (name string, err error) {
+	var rsa syscall.RawSockaddrUnix
+	var l = uint32(syscall.SizeofSockaddrUnix)
+	var trap uintptr = syscall.SYS_GETSOCKNAME
+	if peer {
+		trap = syscall.SYS_GETPEERNAME
+	}
+	_, _, e := syscall.RawSyscall(trap, uintptr(fd), uintptr(unsafe.Pointer(&rsa)), uintptr(unsafe.Pointer(&l)))
+	if e != 0 {
+		return "", syscall.Errno(e)
+	}
+	var n = int(l) - 2 // sizeof(sa_family_t)
+	if n <= 0 {
+		return "", nil
+	}
+	if n > len(rsa.Path) {
+		n = len(rsa.Path)
+	}
+	var path = make([]byte, n)
+	for i := 0; i < n; i++ {
+		path[i] = byte(rsa.Path[i])
+	}
+	if path[0] == 0 {
+		// abstract address
+		path[0] = '@'
+		return string(path), nil
+	}
+	// the pathname may be terminated by NUL
+	for i := range path {
+		if path[i] == 0 {
+			return string(path[:i]), nil
+		}
+	}
+	return string(path), nil
+}